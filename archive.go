@@ -0,0 +1,142 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archive packs the docset into <name>.tgz, the archive format Dash
+// downloads, and writes the <name>.xml feed pointing to it.
+func (d *docset) archive() (err error) {
+	base := strings.TrimSuffix(d.outputDir, ".docset")
+	archivePath := base + ".tgz"
+	err = writeArchive(d.outputDir, archivePath)
+	if err != nil {
+		return
+	}
+
+	version := docsetVersion
+	if version == "" {
+		version = gitVersion(d.config.Module)
+	}
+	url := filepath.Base(archivePath)
+	if archiveURL != "" {
+		url = strings.TrimRight(archiveURL, "/") + "/" + url
+	}
+	feedPath := base + ".xml"
+	err = writeFeed(feedPath, version, url)
+	if err != nil {
+		return
+	}
+	printf("archived %s into %s, feed %s version %s\n", d.outputDir, archivePath, feedPath, version)
+	return
+}
+
+// writeArchive writes the docset in dir as a gzipped tarball, with entries
+// under "<name>.docset/", through a temporary file renamed on success.
+func writeArchive(dir string, archivePath string) (err error) {
+	tmpPath := archivePath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	base := filepath.Dir(dir)
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Name() == ".DS_Store" {
+			return nil
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			header.Name += "/"
+		}
+		err = tw.WriteHeader(header)
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+
+		r, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(tw, r)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	err = tw.Close()
+	if err != nil {
+		return
+	}
+	err = gw.Close()
+	if err != nil {
+		return
+	}
+	err = f.Close()
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmpPath, archivePath)
+	return
+}
+
+// writeFeed writes a Dash docset feed, which Dash/Zeal poll to update the
+// docset when its version changes.
+func writeFeed(feedPath string, version string, url string) (err error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("<entry>\n    <version>")
+	xml.EscapeText(buf, []byte(version))
+	buf.WriteString("</version>\n    <url>")
+	xml.EscapeText(buf, []byte(url))
+	buf.WriteString("</url>\n</entry>\n")
+	err = ioutil.WriteFile(feedPath, buf.Bytes(), 0644)
+	return
+}
+
+// gitVersion describes the git checkout of the documented module with its
+// closest tag, or falls back to a timestamp so every run is a new version.
+// $GOPATH docsets span many checkouts, so they always use the timestamp.
+func gitVersion(moduleDir string) string {
+	timestamp := time.Now().UTC().Format("20060102.150405")
+	if moduleDir == "" {
+		return timestamp
+	}
+	cmd := exec.Command("git", "describe", "--tags", "--always", "--dirty")
+	cmd.Dir = moduleDir
+	out, err := cmd.Output()
+	version := strings.TrimSpace(string(out))
+	if err != nil || version == "" {
+		return timestamp
+	}
+	return version
+}
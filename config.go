@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go/build"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// defaultConfigPath is the config file used when -config is not given.
+const defaultConfigPath = "godocdash.toml"
+
+// docsetConfig describes a docset to generate, either from the command line
+// flags or from a [[docset]] table of the config file.
+type docsetConfig struct {
+	Name     string   `toml:"name"`
+	Icon     string   `toml:"icon"`
+	Output   string   `toml:"output"`
+	GOPATH   string   `toml:"gopath"`
+	Module   string   `toml:"module"`
+	Patterns []string `toml:"patterns"`
+	Deps     bool     `toml:"deps"`
+	Godoc    bool     `toml:"godoc"`
+	Server   string   `toml:"server"`
+	Pkgsite  bool     `toml:"pkgsite"`
+	Std      string   `toml:"std"`
+	Include  []string `toml:"include"`
+	Exclude  []string `toml:"exclude"`
+	Fallback string   `toml:"fallback"`
+	Types    []string `toml:"types"`
+	FullText bool     `toml:"fulltext"`
+	// Header lists "Name: value" headers, and BasicAuth is "user:password",
+	// sent with every request.
+	Header    []string `toml:"header"`
+	BasicAuth string   `toml:"basic-auth"`
+}
+
+type configFile struct {
+	Docsets []docsetConfig `toml:"docset"`
+}
+
+// loadDocsets returns the docsets described by the config file at p, or by
+// defaultConfigPath if it exists. The flags in setFlags override the config
+// file, the other flags only fill the settings missing from it, and
+// relative paths are relative to the config file. Without a config file,
+// the docset described by flags is returned.
+func loadDocsets(p string, flags docsetConfig, setFlags map[string]bool) (docsets []docsetConfig, err error) {
+	if p == "" {
+		if _, statErr := os.Stat(defaultConfigPath); statErr != nil {
+			docsets = []docsetConfig{flags}
+			return
+		}
+		p = defaultConfigPath
+	}
+
+	var config configFile
+	meta, err := toml.DecodeFile(p, &config)
+	if err != nil {
+		return
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		err = fmt.Errorf("%s: unknown setting %q", p, undecoded[0].String())
+		return
+	}
+	if len(config.Docsets) == 0 {
+		err = fmt.Errorf("%s: no [[docset]] found", p)
+		return
+	}
+	printf("using config %s\n", p)
+
+	dir := filepath.Dir(p)
+	names := map[string]bool{}
+	for _, c := range config.Docsets {
+		c = c.merge(flags, setFlags, dir)
+		if names[c.Name] {
+			err = fmt.Errorf("%s: docset name %q is used twice", p, c.Name)
+			return
+		}
+		names[c.Name] = true
+		docsets = append(docsets, c)
+	}
+	return
+}
+
+// merge resolves the relative paths of c from dir, overrides its settings
+// with the flags in setFlags, and fills its missing settings with the
+// other flags.
+func (c docsetConfig) merge(flags docsetConfig, setFlags map[string]bool, dir string) docsetConfig {
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	c.Icon = resolve(c.Icon)
+	c.Output = resolve(c.Output)
+	c.Module = resolve(c.Module)
+	var gopath []string
+	for _, root := range filepath.SplitList(c.GOPATH) {
+		gopath = append(gopath, resolve(root))
+	}
+	c.GOPATH = strings.Join(gopath, string(filepath.ListSeparator))
+
+	str := func(name string, value *string, flagValue string) {
+		if setFlags[name] || *value == "" {
+			*value = flagValue
+		}
+	}
+	list := func(name string, value *[]string, flagValue []string) {
+		if setFlags[name] || len(*value) == 0 {
+			*value = flagValue
+		}
+	}
+	boolean := func(name string, value *bool, flagValue bool) {
+		if setFlags[name] {
+			*value = flagValue
+		}
+	}
+	str("name", &c.Name, flags.Name)
+	str("icon", &c.Icon, flags.Icon)
+	str("output", &c.Output, flags.Output)
+	if c.GOPATH == "" {
+		c.GOPATH = flags.GOPATH
+	}
+	str("module", &c.Module, flags.Module)
+	// the package patterns are the arguments
+	if len(flags.Patterns) > 0 {
+		c.Patterns = flags.Patterns
+	}
+	boolean("deps", &c.Deps, flags.Deps)
+	boolean("godoc", &c.Godoc, flags.Godoc)
+	str("server", &c.Server, flags.Server)
+	boolean("pkgsite", &c.Pkgsite, flags.Pkgsite)
+	str("std", &c.Std, flags.Std)
+	list("include", &c.Include, flags.Include)
+	list("exclude", &c.Exclude, flags.Exclude)
+	str("fallback", &c.Fallback, flags.Fallback)
+	list("types", &c.Types, flags.Types)
+	boolean("fulltext", &c.FullText, flags.FullText)
+	list("header", &c.Header, flags.Header)
+	str("basic-auth", &c.BasicAuth, flags.BasicAuth)
+	return c
+}
+
+// sourceKey identifies the sources of a docset, docsets with the same
+// sources are generated from a single package scan.
+func (c docsetConfig) sourceKey() string {
+	return fmt.Sprintf("%q %q %q %t %t %q %t %q %q %q", c.GOPATH, c.Module, c.Patterns, c.Deps, c.Godoc, c.Server, c.Pkgsite, c.Std, c.Header, c.BasicAuth)
+}
+
+// docsetSource holds the source settings of a group of docsets, in place
+// of the process environment and of the defaults of go/build.
+type docsetSource struct {
+	// build is the build context of the packages, with the GOPATH of the
+	// docsets, and env the environment of the go command and of godoc.
+	build build.Context
+	env   []string
+
+	module   string
+	patterns []string
+	deps     bool
+	std      string
+
+	// godoc reports whether the pages are scraped from a spawned godoc or
+	// from the -server, and pkgsite whether the -server is pkgsite.
+	godoc   bool
+	pkgsite bool
+	server  string
+	// header is sent with every request to the server, along with the
+	// basic authentication.
+	header http.Header
+}
+
+// newDocsetSource checks the source settings of a docset.
+func newDocsetSource(c docsetConfig) (s *docsetSource, err error) {
+	if c.Godoc && c.Module != "" {
+		err = errors.New("-module can not be used with -godoc")
+		return
+	}
+	if c.Pkgsite && c.Server == "" {
+		err = errors.New("-pkgsite requires -server")
+		return
+	}
+	if c.Pkgsite && c.Godoc {
+		err = errors.New("-pkgsite can not be used with -godoc")
+		return
+	}
+	if c.Server != "" && !c.Pkgsite && c.Module != "" {
+		err = errors.New("-module can not be used with -server")
+		return
+	}
+	if c.Std != "" && c.Std != "only" && c.Std != "include" {
+		err = errors.New("-std must be \"only\" or \"include\"")
+		return
+	}
+	header := http.Header{}
+	for _, value := range c.Header {
+		err = addHeader(header, value)
+		if err != nil {
+			return
+		}
+	}
+	basicAuth := c.BasicAuth
+	if basicAuth == "" {
+		basicAuth = os.Getenv("GODOCDASH_BASIC_AUTH")
+	}
+	if basicAuth != "" {
+		if !strings.Contains(basicAuth, ":") {
+			basicAuth += ":"
+		}
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(basicAuth)))
+	}
+
+	s = &docsetSource{
+		build:    build.Default,
+		module:   c.Module,
+		patterns: c.Patterns,
+		deps:     c.Deps,
+		std:      c.Std,
+		godoc:    c.Godoc || (c.Server != "" && !c.Pkgsite),
+		pkgsite:  c.Pkgsite,
+		server:   strings.TrimRight(c.Server, "/"),
+		header:   header,
+	}
+	if c.GOPATH != "" {
+		s.build.GOPATH = c.GOPATH
+		// the go command and godoc read it from the environment
+		s.env = append(os.Environ(), "GOPATH="+c.GOPATH)
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	flags := docsetConfig{
+		Name:     "GoDoc",
+		Output:   "out",
+		GOPATH:   "/go",
+		Fallback: "https://pkg.go.dev/",
+		Deps:     false,
+		Include:  []string{"example.com/**"},
+	}
+	c := docsetConfig{
+		Name:    "Conf",
+		Output:  "build",
+		Deps:    true,
+		Include: []string{"github.com/ourorg/**"},
+		Header:  []string{"X-Team: platform"},
+	}
+
+	got := c.merge(flags, map[string]bool{}, "dir")
+	want := docsetConfig{
+		Name:     "Conf",
+		Output:   filepath.Join("dir", "build"),
+		GOPATH:   "/go",
+		Fallback: "https://pkg.go.dev/",
+		Deps:     true,
+		Include:  []string{"github.com/ourorg/**"},
+		Header:   []string{"X-Team: platform"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merge without flags set = %+v, want %+v", got, want)
+	}
+
+	got = c.merge(flags, map[string]bool{"name": true, "deps": true, "include": true, "output": true}, "dir")
+	want.Name = "GoDoc"
+	want.Output = "out"
+	want.Deps = false
+	want.Include = []string{"example.com/**"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merge with flags set = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const partialSuffix = ".partial"
+const backupSuffix = ".bak"
+
+// docset is a docset generated by a run, with its own settings, packages
+// and index.
+type docset struct {
+	config docsetConfig
+	source *docsetSource
+	filter *packageFilter
+
+	// outputDir is the docset to generate, and dir the staging directory it
+	// is built into.
+	outputDir string
+	dir       string
+
+	// packages holds the names of the packages generated into the docset,
+	// and selected lists them in scan order.
+	packages map[string]bool
+	selected []string
+
+	manifest *manifest
+	db       *sql.DB
+	tx       *sql.Tx
+	stmt     *sql.Stmt
+	// textStmt inserts into the full-text index, with -fulltext.
+	textStmt *sql.Stmt
+}
+
+func newDocset(c docsetConfig) (d *docset, err error) {
+	for _, t := range c.Types {
+		if !containsString(entryTypeNames, t) {
+			err = fmt.Errorf("unknown entry type %q", t)
+			return
+		}
+	}
+	filter, err := newPackageFilter(c.Include, c.Exclude)
+	if err != nil {
+		return
+	}
+	d = &docset{
+		config:    c,
+		filter:    filter,
+		outputDir: filepath.Join(c.Output, c.Name+".docset"),
+		packages:  map[string]bool{},
+	}
+	return
+}
+
+// add selects the package for the docset if it matches its filter.
+func (d *docset) add(packageName string) bool {
+	if !d.filter.Match(packageName) {
+		return false
+	}
+	d.packages[packageName] = true
+	d.selected = append(d.selected, packageName)
+	return true
+}
+
+// drop removes a package from the docset.
+func (d *docset) drop(packageName string) {
+	delete(d.packages, packageName)
+	for i, name := range d.selected {
+		if name == packageName {
+			d.selected = append(d.selected[:i:i], d.selected[i+1:]...)
+			break
+		}
+	}
+}
+
+// reset clears the packages of the docset before a new package scan.
+func (d *docset) reset() {
+	d.packages = map[string]bool{}
+	d.selected = nil
+}
+
+// open creates the staging directory next to the docset, and writes its
+// icon, plist and index DB, in which the indexes are inserted in a
+// transaction. When incremental, the previous docset is copied to be
+// updated according to its manifest.
+func (d *docset) open(incremental bool) (err error) {
+	defer func() {
+		if err != nil {
+			d.close(err)
+		}
+	}()
+
+	err = os.MkdirAll(filepath.Dir(d.outputDir), 0755)
+	if err != nil {
+		return
+	}
+	d.dir, err = ioutil.TempDir(filepath.Dir(d.outputDir), "."+filepath.Base(d.outputDir)+".staging-")
+	if err != nil {
+		return
+	}
+	err = os.Chmod(d.dir, 0755)
+	if err != nil {
+		return
+	}
+
+	// previous docset
+	options := fmt.Sprintf("godoc=%t server=%s pkgsite=%t fallback=%s types=%s fulltext=%t", d.source.godoc, d.source.server, d.source.pkgsite, d.config.Fallback, strings.Join(d.config.Types, ","), d.config.FullText)
+	if d.source.godoc || d.source.pkgsite {
+		// the package links of every page depend on the packages of the docset
+		selected := append([]string{}, d.selected...)
+		sort.Strings(selected)
+		options += " packages=" + strings.Join(selected, ",")
+	}
+	d.manifest = newManifest(d.dir, options)
+	update := incremental && d.manifest.loadPrevious(d.outputDir)
+	if update {
+		printf("updating %s\n", d.outputDir)
+		err = copyDir(d.outputDir, d.dir)
+		if err != nil {
+			return
+		}
+	}
+
+	// icon
+	err = writeIcon(d.dir, d.config.Icon)
+	if err != nil {
+		return
+	}
+
+	// plist
+	err = genPlist(d.dir, d.config.Name)
+	if err != nil {
+		return
+	}
+
+	// DB
+	if update {
+		d.db, err = openDB(d.dir)
+	} else {
+		d.db, err = createDB(d.dir)
+	}
+	if err != nil {
+		return
+	}
+	err = d.begin(!update)
+	return
+}
+
+// openLive opens the generated docset to update it in place with -watch,
+// as Dash/Zeal reload a docset when it changes.
+func (d *docset) openLive() (err error) {
+	defer func() {
+		if err != nil {
+			d.finish()
+		}
+	}()
+
+	d.dir = d.outputDir
+	d.manifest = newManifest(d.dir, "")
+	err = d.manifest.loadCurrent()
+	if err != nil {
+		return
+	}
+	d.db, err = openDB(d.dir)
+	if err != nil {
+		return
+	}
+	err = d.begin(false)
+	return
+}
+
+// begin starts the transaction the indexes are inserted in, creating the
+// full-text index of a new docset with -fulltext.
+func (d *docset) begin(create bool) (err error) {
+	d.tx, err = d.db.Begin()
+	if err != nil {
+		return
+	}
+	d.manifest.tx = d.tx
+	d.stmt, err = d.tx.Prepare(insertSQL)
+	if err != nil || !d.config.FullText {
+		return
+	}
+	d.manifest.fullText = true
+	if create {
+		err = createTextIndex(d.tx)
+		if err != nil {
+			return
+		}
+	}
+	d.textStmt, err = d.tx.Prepare(insertTextSQL)
+	return
+}
+
+// finish commits the indexes and closes the index DB.
+func (d *docset) finish() (err error) {
+	if d.stmt != nil {
+		d.stmt.Close()
+		d.stmt = nil
+	}
+	if d.textStmt != nil {
+		d.textStmt.Close()
+		d.textStmt = nil
+	}
+	if d.tx != nil {
+		err = d.tx.Commit()
+		d.tx = nil
+	}
+	if d.db != nil {
+		d.db.Close()
+		d.db = nil
+	}
+	return
+}
+
+// insert inserts the indexes of a package, and its documentation into the
+// full-text index with -fulltext.
+func (d *docset) insert(info *packageInfo) (err error) {
+	err = info.WriteInsert(d.stmt, d.config.Types)
+	if err != nil || d.textStmt == nil {
+		return
+	}
+	err = info.WriteText(d.textStmt, d.config.Types)
+	return
+}
+
+// packagesError reports the packages of a docset which failed to generate.
+type packagesError struct {
+	outputDir string
+	packages  []string
+}
+
+func (err *packagesError) Error() string {
+	packages := append([]string{}, err.packages...)
+	sort.Strings(packages)
+	return fmt.Sprintf("%s: %d packages failed: %s", err.outputDir, len(packages), strings.Join(packages, ", "))
+}
+
+// packagesErr returns a *packagesError when packages of the docset failed
+// to generate, or nil.
+func (d *docset) packagesErr() error {
+	if len(d.manifest.failed) == 0 {
+		return nil
+	}
+	return &packagesError{outputDir: d.outputDir, packages: d.manifest.failed}
+}
+
+// close commits the indexes, and replaces the existing docset unless the
+// generation itself failed. A *packagesError doesn't prevent the swap and
+// is returned once done, the indexes of an interrupted generation are kept
+// and the docset is marked partial, and any other failed one is removed.
+func (d *docset) close(err error) error {
+	failedErr, failed := err.(*packagesError)
+	if failed {
+		err = nil
+	}
+	if err == nil {
+		err = d.manifest.Prune()
+	}
+	if err == nil {
+		err = d.manifest.write()
+	}
+	finishErr := d.finish()
+	if err == nil {
+		err = finishErr
+	}
+	if d.dir == "" {
+		return err
+	}
+
+	if err == context.Canceled {
+		markErr := d.markPartial()
+		if markErr != nil {
+			return markErr
+		}
+		fmt.Printf("partial docset left in %s\n", d.outputDir+partialSuffix)
+		return err
+	}
+	if err != nil {
+		os.RemoveAll(d.dir)
+		return err
+	}
+	err = d.swap()
+	if err == nil && archive {
+		err = d.archive()
+	}
+	if err == nil && failed {
+		err = failedErr
+	}
+	return err
+}
+
+// closeLive commits the indexes of a docset updated in place. The pages
+// are already updated, so the manifest is written even when err is not nil.
+func (d *docset) closeLive(err error) error {
+	writeErr := d.manifest.write()
+	finishErr := d.finish()
+	if err == nil {
+		err = writeErr
+	}
+	if err == nil {
+		err = finishErr
+	}
+	return err
+}
+
+// swap moves the staging directory in place of the docset. With -backup
+// the previous docset is kept with the backupSuffix.
+func (d *docset) swap() (err error) {
+	oldDir := ""
+	if _, statErr := os.Stat(d.outputDir); statErr == nil {
+		oldDir = d.dir + ".old"
+		if keepBackup {
+			oldDir = d.outputDir + backupSuffix
+			err = os.RemoveAll(oldDir)
+			if err != nil {
+				return
+			}
+		}
+		err = os.Rename(d.outputDir, oldDir)
+		if err != nil {
+			return
+		}
+	}
+
+	err = os.Rename(d.dir, d.outputDir)
+	if err != nil {
+		// put the previous docset back
+		if oldDir != "" {
+			os.Rename(oldDir, d.outputDir)
+		}
+		return
+	}
+	if oldDir != "" && !keepBackup {
+		err = os.RemoveAll(oldDir)
+	}
+	return
+}
+
+// markPartial renames an interrupted staging directory to the docset name
+// with the partialSuffix, so it is not mistaken for a complete one and not
+// loaded by Dash.
+func (d *docset) markPartial() (err error) {
+	partialDir := d.outputDir + partialSuffix
+	err = os.RemoveAll(partialDir)
+	if err != nil {
+		return
+	}
+	err = os.Rename(d.dir, partialDir)
+	return
+}
+
+// createDocsets opens the docsets, calls generate to write the pages and
+// insert the indexes of all of them, then closes them. The existing docsets
+// are left in place when the generation is interrupted or generate fails,
+// and replaced otherwise, a *packagesError being returned when packages
+// failed.
+func createDocsets(ctx context.Context, docsets []*docset, incremental bool, generate func() error) (err error) {
+	var opened []*docset
+	for _, d := range docsets {
+		err = d.open(incremental)
+		if err != nil {
+			break
+		}
+		opened = append(opened, d)
+	}
+	if err == nil {
+		runErr := generate()
+		err = ctx.Err()
+		if err == nil {
+			err = runErr
+		}
+	}
+
+	generateErr := err
+	for _, d := range opened {
+		closeErr := generateErr
+		if closeErr == nil {
+			closeErr = d.packagesErr()
+		}
+		closeErr = d.close(closeErr)
+		if err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// updateInPlace opens the generated docsets in place, calls update to
+// regenerate packages, then closes them. A *packagesError is returned when
+// packages failed.
+func updateInPlace(ctx context.Context, docsets []*docset, update func()) (err error) {
+	var opened []*docset
+	for _, d := range docsets {
+		err = d.openLive()
+		if err != nil {
+			break
+		}
+		opened = append(opened, d)
+	}
+	if err == nil {
+		update()
+		err = ctx.Err()
+	}
+
+	updateErr := err
+	for _, d := range opened {
+		closeErr := updateErr
+		if closeErr == nil {
+			closeErr = d.packagesErr()
+		}
+		closeErr = d.closeLive(closeErr)
+		if err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// printSelected lists the packages selected for each docset, for -dry-run.
+func printSelected(docsets []*docset) {
+	for _, d := range docsets {
+		prefix := ""
+		if len(docsets) > 1 {
+			fmt.Printf("%s:\n", d.outputDir)
+			prefix = "\t"
+		}
+		for _, packageName := range d.selected {
+			fmt.Println(prefix + packageName)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{}
+var retries int
+
+// addHeader adds a "Name: value" header to h.
+func addHeader(h http.Header, value string) error {
+	i := strings.Index(value, ":")
+	if i <= 0 {
+		return fmt.Errorf("header %q is not \"Name: value\"", value)
+	}
+	h.Add(strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:]))
+	return nil
+}
+
+const retryDelay = 500 * time.Millisecond
+
+// fetch gets the body of url with header, for servers protected by
+// authentication, retrying with an exponential backoff on transient
+// failures: network errors, 5xx and 429 responses.
+func fetch(ctx context.Context, url string, header http.Header) (buf []byte, err error) {
+	for attempt := 0; ; attempt++ {
+		var transient bool
+		buf, transient, err = fetchOnce(ctx, url, header)
+		if err == nil || !transient || attempt >= retries {
+			return
+		}
+		delay := retryDelay << uint(attempt)
+		printf("retrying %s in %s: %s\n", url, delay, err.Error())
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+func fetchOnce(ctx context.Context, url string, header http.Header) (buf []byte, transient bool, err error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		// network errors, including timeouts, unless canceled
+		transient = ctx.Err() == nil
+		return
+	}
+	defer resp.Body.Close()
+	buf, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		transient = true
+		return
+	}
+	if resp.StatusCode >= 400 {
+		transient = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		err = fmt.Errorf("get %s: %s", url, resp.Status)
+	}
+	return
+}
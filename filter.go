@@ -0,0 +1,106 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// patternList is a flag.Value collecting a repeatable flag.
+type patternList []string
+
+func (list *patternList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *patternList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// packageFilter selects packages by import path.
+type packageFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newPackageFilter compiles include and exclude patterns. A pattern is a
+// glob where "*" matches inside a path element and "**" matches any number
+// of elements, or a regular expression when prefixed with "re:".
+func newPackageFilter(includes []string, excludes []string) (filter *packageFilter, err error) {
+	filter = &packageFilter{}
+	filter.include, err = compilePatterns(includes)
+	if err != nil {
+		return
+	}
+	filter.exclude, err = compilePatterns(excludes)
+	return
+}
+
+// Match reports whether the package matches one of the include patterns, if
+// any, and none of the exclude patterns.
+func (filter *packageFilter) Match(packageName string) bool {
+	if len(filter.include) > 0 && !matchAny(filter.include, packageName) {
+		return false
+	}
+	return !matchAny(filter.exclude, packageName)
+}
+
+func matchAny(list []*regexp.Regexp, packageName string) bool {
+	for _, re := range list {
+		if re.MatchString(packageName) {
+			return true
+		}
+	}
+	return false
+}
+
+func compilePatterns(patterns []string) (list []*regexp.Regexp, err error) {
+	for _, pattern := range patterns {
+		expr := globRegexp(pattern)
+		if strings.HasPrefix(pattern, "re:") {
+			expr = strings.TrimPrefix(pattern, "re:")
+		}
+		var re *regexp.Regexp
+		re, err = regexp.Compile(expr)
+		if err != nil {
+			return
+		}
+		list = append(list, re)
+	}
+	return
+}
+
+// globRegexp converts a glob to an anchored regular expression, e.g.
+// "github.com/ourorg/**" matches "github.com/ourorg" and all its
+// subpackages.
+func globRegexp(glob string) string {
+	if glob == "**" {
+		return "^.*$"
+	}
+	elems := strings.Split(glob, "/")
+	expr := "^"
+	for i, elem := range elems {
+		if elem == "**" {
+			if i == 0 {
+				expr += "(.*/)?"
+			} else {
+				expr += "(/.*)?"
+			}
+			continue
+		}
+		if i > 0 && !(i == 1 && elems[0] == "**") {
+			expr += "/"
+		}
+		for _, r := range elem {
+			switch r {
+			case '*':
+				expr += "[^/]*"
+			case '?':
+				expr += "[^/]"
+			default:
+				expr += regexp.QuoteMeta(string(r))
+			}
+		}
+	}
+	return expr + "$"
+}
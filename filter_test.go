@@ -0,0 +1,73 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		name  string
+		match bool
+	}{
+		{"**", "github.com/foo/bar", true},
+		{"github.com/foo/**", "github.com/foo", true},
+		{"github.com/foo/**", "github.com/foo/bar", true},
+		{"github.com/foo/**", "github.com/foo/bar/baz", true},
+		{"github.com/foo/**", "github.com/foobar", false},
+		{"**/internal", "internal", true},
+		{"**/internal", "github.com/foo/internal", true},
+		{"**/internal", "github.com/foo/internals", false},
+		{"github.com/**/cmd", "github.com/cmd", true},
+		{"github.com/**/cmd", "github.com/foo/bar/cmd", true},
+		{"github.com/*", "github.com/foo", true},
+		{"github.com/*", "github.com/foo/bar", false},
+		{"github.com/foo*", "github.com/foobar", true},
+		{"github.com/foo?", "github.com/foo1", true},
+		{"github.com/foo?", "github.com/foo/", false},
+		{"gopkg.in/yaml.v2", "gopkg.in/yaml.v2", true},
+		{"gopkg.in/yaml.v2", "gopkgxin/yaml_v2", false},
+		{"github.com/foo", "github.com/foo/bar", false},
+		{"(a)+", "(a)+", true},
+		{"(a)+", "aa", false},
+	}
+	for _, test := range tests {
+		expr := globRegexp(test.glob)
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			t.Errorf("globRegexp(%q) = %q: %s", test.glob, expr, err)
+			continue
+		}
+		if match := re.MatchString(test.name); match != test.match {
+			t.Errorf("globRegexp(%q) = %q, matching %q = %t, want %t", test.glob, expr, test.name, match, test.match)
+		}
+	}
+}
+
+func TestPackageFilter(t *testing.T) {
+	filter, err := newPackageFilter([]string{"github.com/foo/**", `re:^example\.com/`}, []string{"**/internal/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		match bool
+	}{
+		{"github.com/foo/bar", true},
+		{"example.com/bar", true},
+		{"github.com/bar", false},
+		{"github.com/foo/internal", false},
+		{"github.com/foo/internal/bar", false},
+	}
+	for _, test := range tests {
+		if match := filter.Match(test.name); match != test.match {
+			t.Errorf("Match(%q) = %t, want %t", test.name, match, test.match)
+		}
+	}
+
+	_, err = newPackageFilter([]string{"re:("}, nil)
+	if err == nil {
+		t.Error("newPackageFilter accepted an invalid regexp")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// The full-text index is a searchText table of docSet.dsidx, with the same
+// name, type and path columns as searchIndex, so that any tool reading the
+// docset index can query it. It is an FTS5 table when go-sqlite3 is built
+// with the sqlite_fts5 tag, or an FTS4 one otherwise. Words are stemmed, so
+// that e.g. "retry" matches "retries".
+const createTextSQL = "CREATE VIRTUAL TABLE searchText USING fts5(name, type UNINDEXED, path UNINDEXED, text, tokenize='porter unicode61')"
+const createTextFTS4SQL = "CREATE VIRTUAL TABLE searchText USING fts4(name, type, path, text, notindexed=type, notindexed=path, tokenize=porter)"
+const insertTextSQL = "INSERT INTO searchText(name, type, path, text) VALUES (?,?,?,?)"
+
+// textSnippetSize is the number of tokens of the snippets of the full-text
+// search results.
+const textSnippetSize = 16
+
+// packageText is the documentation prose of an entry of the package, the
+// package itself for its overview.
+type packageText struct {
+	Index packageIndex
+	Type  string
+	Text  string
+}
+
+type textResult struct {
+	searchResult
+	Snippet string
+}
+
+// ParseText collects the overview of the package and the doc comments of its
+// constants, variables, functions, methods and types, from a godoc or
+// pkgsite page. It must run after the other parsers, before the page is
+// modified.
+func (info *packageInfo) ParseText(doc *goquery.Document) {
+	if text := overviewText(doc); text != "" {
+		info.Texts = append(info.Texts, packageText{Type: "Package", Text: text})
+	}
+
+	groups := []struct {
+		typeName string
+		indexes  []packageIndex
+	}{
+		{"Constant", info.Consts},
+		{"Variable", info.Variables},
+		{"Function", info.Funcs},
+		{"Method", info.Methods},
+		{"Type", info.Types},
+	}
+	for _, group := range groups {
+		for _, index := range group.indexes {
+			if !strings.HasPrefix(index.Path, "#") {
+				continue
+			}
+			selection := doc.Find(`[id="` + index.Path[1:] + `"]`).First()
+			text := declarationText(selection, info.valueCommentsFollow)
+			if text == "" {
+				continue
+			}
+			info.Texts = append(info.Texts, packageText{
+				Index: index,
+				Type:  index.entryType(group.typeName),
+				Text:  text,
+			})
+		}
+	}
+}
+
+func overviewText(doc *goquery.Document) string {
+	return paragraphText(overviewElements(doc), overviewSelector)
+}
+
+// declarationText returns the doc comment of the declaration anchored by
+// selection: a godoc heading followed by its comment, a pkgsite heading
+// within the element holding its comment, or the first name of a constant
+// or variable declaration, whose comment precedes it on godoc, and follows
+// it on pkgsite and with valueCommentsFollow. Interface methods have no
+// comment of their own.
+func declarationText(selection *goquery.Selection, valueCommentsFollow bool) string {
+	switch goquery.NodeName(selection) {
+	case "h2", "h3":
+		text := selection.NextUntil("h2, h3")
+		if strings.HasPrefix(selection.Text(), "type ") {
+			// the comment precedes the declaration, followed by the
+			// comments of the constants and variables of the type
+			text = selection.NextUntilSelection(typeDecl(selection))
+		}
+		return paragraphText(text, commentSelector)
+	case "h4":
+		return paragraphText(selection.Parent().Children(), commentSelector)
+	case "span":
+		pre := selection.Closest("pre")
+		if pre.Length() == 0 || !strings.HasPrefix(pre.Text(), "const") && !strings.HasPrefix(pre.Text(), "var") {
+			return ""
+		}
+		// the comment of a group is indexed once
+		if pre.Find("span[id]").First().AttrOr("id", "") != selection.AttrOr("id", "") {
+			return ""
+		}
+		if decl := pre.Closest("div.Documentation-declaration"); decl.Length() > 0 {
+			return paragraphText(decl.NextUntil("div.Documentation-declaration"), commentSelector)
+		}
+		if valueCommentsFollow {
+			return paragraphText(pre.NextUntil("pre, h2, h3, div"), commentSelector)
+		}
+		return paragraphText(pre.PrevUntil("pre, h2, h3, div"), commentSelector)
+	}
+	return ""
+}
+
+// commentSelector matches the elements of a doc comment, and
+// overviewSelector the ones of the package overview, including its headings.
+const commentSelector = "p, ul, ol"
+const overviewSelector = commentSelector + ", h3, h4"
+
+// paragraphText returns the text of the elements of selection matching
+// selector, with whitespace collapsed.
+func paragraphText(selection *goquery.Selection, selector string) string {
+	var paragraphs []string
+	selection.Filter(selector).Each(func(index int, paragraph *goquery.Selection) {
+		if text := strings.Join(strings.Fields(paragraph.Text()), " "); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	})
+	return strings.Join(paragraphs, "\n")
+}
+
+// WriteText inserts the documentation prose of the package into the
+// full-text index, only keeping the entry types listed in types if any.
+func (info *packageInfo) WriteText(stmt *sql.Stmt, types []string) (err error) {
+	for _, text := range info.Texts {
+		if !keepEntryType(types, text.Type) {
+			continue
+		}
+		name := info.Name
+		if text.Index.Name != "" {
+			name = info.entryName(text.Type, text.Index)
+		}
+		_, err = stmt.Exec(name, text.Type, getDocumentPath(info.Name)+text.Index.Path, text.Text)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// createTextIndex creates the full-text index of a docset, with FTS4 when
+// FTS5 is not built in.
+func createTextIndex(tx *sql.Tx) (err error) {
+	var fts5 bool
+	err = tx.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5)
+	if err != nil {
+		return
+	}
+	if fts5 {
+		_, err = tx.Exec(createTextSQL)
+		return
+	}
+	printf("FTS5 is not built in (see -tags sqlite_fts5), using FTS4 for the full-text index\n")
+	_, err = tx.Exec(createTextFTS4SQL)
+	return
+}
+
+// textIndexModule returns the module of the full-text index of a docset,
+// "fts5" or "fts4", or "" when the docset has none.
+func textIndexModule(db *sql.DB) (module string, err error) {
+	var createSQL string
+	err = db.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'searchText'").Scan(&createSQL)
+	if err == sql.ErrNoRows {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	module = "fts4"
+	if strings.Contains(strings.ToLower(createSQL), "fts5") {
+		module = "fts5"
+	}
+	return
+}
+
+// searchText returns the entries whose documentation contains every word of
+// query, the best matches first with FTS5, along with a snippet of their
+// documentation.
+func searchText(db *sql.DB, module string, query string, limit int) (results []textResult, err error) {
+	var words []string
+	for _, word := range strings.Fields(query) {
+		// quoted, words are not parsed as query operators
+		words = append(words, `"`+strings.Replace(word, `"`, `""`, -1)+`"`)
+	}
+	if len(words) == 0 {
+		return
+	}
+
+	textSQL := fmt.Sprintf(`SELECT name, type, path, snippet(searchText, 3, '', '', '…', %d) FROM searchText
+		WHERE searchText MATCH ? ORDER BY rank LIMIT ?`, textSnippetSize)
+	if module == "fts4" {
+		textSQL = fmt.Sprintf(`SELECT name, type, path, snippet(searchText, '', '', '…', 3, %d) FROM searchText
+			WHERE searchText MATCH ? LIMIT ?`, textSnippetSize)
+	}
+	rows, err := db.Query(textSQL, strings.Join(words, " "), limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result textResult
+		err = rows.Scan(&result.Name, &result.Type, &result.Path, &result.Snippet)
+		if err != nil {
+			return
+		}
+		result.Snippet = strings.Join(strings.Fields(result.Snippet), " ")
+		results = append(results, result)
+	}
+	err = rows.Err()
+	return
+}
+
+// search implements the search subcommand, printing the entries of a docset
+// whose documentation matches the query.
+func search(args []string) (err error) {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: godocdash search [flags] <query>\n")
+		flags.PrintDefaults()
+	}
+	docsetPath := flags.String("docset", "GoDoc.docset", "Docset to search, generated with -fulltext")
+	limit := flags.Int("limit", searchLimit, "Maximum number of results")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	db, err := openDocsetIndex(*docsetPath)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	module, err := textIndexModule(db)
+	if err != nil {
+		return
+	}
+	if module == "" {
+		err = fmt.Errorf("%s has no full-text index, generate it with -fulltext", *docsetPath)
+		return
+	}
+
+	results, err := searchText(db, module, strings.Join(flags.Args(), " "), *limit)
+	if err != nil {
+		return
+	}
+	documentsDir := filepath.Join(getResourcesDir(*docsetPath), "Documents")
+	for _, result := range results {
+		fmt.Printf("%s (%s)\n\t%s\n\t%s\n", result.Name, result.Type, filepath.Join(documentsDir, result.Path), result.Snippet)
+	}
+	return
+}
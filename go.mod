@@ -3,6 +3,7 @@ module github.com/wuudjac/godocdash
 go 1.12
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/PuerkitoBio/goquery v1.5.0
 	github.com/mattn/go-sqlite3 v1.11.0
 )
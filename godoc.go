@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// godocTimeout bounds the startup of godoc, until its index is complete.
+var godocTimeout time.Duration
+
+const godocPollInterval = 500 * time.Millisecond
+
+// godocStablePolls is the number of consecutive polls listing the same
+// number of packages after which the package list is deemed complete.
+const godocStablePolls = 3
+
+// godocStderrLimit is the size of the end of godoc stderr reported when it
+// exits early.
+const godocStderrLimit = 2048
+
+// godocServer is a spawned godoc process serving on host.
+type godocServer struct {
+	ctx    context.Context
+	cmd    *exec.Cmd
+	host   string
+	stderr *bytes.Buffer
+
+	// exited is closed once the process exited, with the error it exited
+	// with in waitErr.
+	exited  chan struct{}
+	waitErr error
+}
+
+// runGodoc spawns godoc with its search index on a free port, with the
+// environment env or the current one if nil, and waits for it to be ready.
+func runGodoc(ctx context.Context, env []string) (server *godocServer, err error) {
+	godocPath, err := exec.LookPath("godoc")
+	if err != nil {
+		err = errors.New("godoc not found in $PATH, install it with \"go install golang.org/x/tools/cmd/godoc@latest\", or generate the docset without -godoc")
+		return
+	}
+
+	// get a free port
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return
+	}
+	addr := l.Addr()
+	l.Close()
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		err = errors.New("failed to find a free port: " + addr.String())
+		return
+	}
+
+	// try running godoc on this port
+	tryHost := "localhost:" + strconv.Itoa(tcpAddr.Port)
+	server = &godocServer{
+		ctx:    ctx,
+		cmd:    exec.CommandContext(ctx, godocPath, "-http="+tryHost, "-index"),
+		host:   "http://" + tryHost,
+		stderr: &bytes.Buffer{},
+		exited: make(chan struct{}),
+	}
+	server.cmd.Stderr = server.stderr
+	if !silent {
+		server.cmd.Stderr = io.MultiWriter(os.Stderr, server.stderr)
+		server.cmd.Stdout = os.Stdout
+	}
+	server.cmd.Env = env
+	err = server.cmd.Start()
+	if err != nil {
+		return
+	}
+	go func() {
+		server.waitErr = server.cmd.Wait()
+		close(server.exited)
+	}()
+
+	err = server.waitReady()
+	if err != nil {
+		server.stop()
+	}
+	return
+}
+
+// waitReady polls godoc until its search index is built and its package
+// list is stable, as godoc serves partial results while indexing.
+func (server *godocServer) waitReady() (err error) {
+	printf("waiting for godoc on %s to index packages\n", server.host)
+	deadline := time.After(godocTimeout)
+	count, stable := 0, 0
+	var lastErr error
+	for {
+		select {
+		case <-time.After(godocPollInterval):
+		case <-server.exited:
+			err = fmt.Errorf("godoc exited before being ready: %v", server.waitErr)
+			if output := strings.TrimSpace(server.stderrTail()); output != "" {
+				err = fmt.Errorf("%s\n%s", err.Error(), output)
+			}
+			return
+		case <-deadline:
+			err = fmt.Errorf("godoc not ready after %s, see -godoc-timeout", godocTimeout)
+			if lastErr != nil {
+				err = fmt.Errorf("%s: %s", err.Error(), lastErr.Error())
+			}
+			return
+		case <-server.ctx.Done():
+			err = server.ctx.Err()
+			return
+		}
+
+		var indexed bool
+		var n int
+		indexed, n, lastErr = server.poll()
+		if lastErr != nil {
+			continue
+		}
+		if n > 0 && n == count {
+			stable++
+		} else {
+			count, stable = n, 1
+		}
+		if indexed && stable >= godocStablePolls {
+			printf("godoc on %s is ready with %d packages\n", server.host, count)
+			return
+		}
+	}
+}
+
+// poll reports whether the search index of godoc is built, and the number
+// of packages it lists.
+func (server *godocServer) poll() (indexed bool, count int, err error) {
+	buf, _, err := fetchOnce(server.ctx, server.host+"/search?q=godocdash", nil)
+	if err != nil {
+		return
+	}
+	indexed = !bytes.Contains(buf, []byte("Indexing in progress"))
+
+	buf, _, err = fetchOnce(server.ctx, server.host+"/pkg/", nil)
+	if err != nil {
+		return
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(buf))
+	if err != nil {
+		return
+	}
+	count = doc.Find("div.pkg-dir td.pkg-name a").Length()
+	return
+}
+
+func (server *godocServer) stderrTail() string {
+	output := server.stderr.String()
+	if len(output) > godocStderrLimit {
+		output = "..." + output[len(output)-godocStderrLimit:]
+	}
+	return output
+}
+
+// exitErr returns an error when godoc exited, or nil while it is running.
+func (server *godocServer) exitErr() error {
+	select {
+	case <-server.exited:
+	default:
+		return nil
+	}
+	err := fmt.Errorf("godoc exited: %v", server.waitErr)
+	if output := strings.TrimSpace(server.stderrTail()); output != "" {
+		err = fmt.Errorf("%s\n%s", err.Error(), output)
+	}
+	return err
+}
+
+// stop kills godoc, unless it already exited or was killed with the
+// canceled context, and waits for it to exit.
+func (server *godocServer) stop() {
+	select {
+	case <-server.exited:
+		return
+	default:
+	}
+	if server.ctx.Err() == nil {
+		printf("killing godoc on %s\n", server.host)
+		err := server.cmd.Process.Kill()
+		if err != nil {
+			fmt.Printf("error killing godoc on %s: %s\n", server.host, err.Error())
+		}
+	}
+	<-server.exited
+}
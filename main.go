@@ -3,21 +3,19 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
-	"errors"
 	"flag"
 	"fmt"
+	"go/build"
 	"io"
-	"io/ioutil"
-	"net"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -28,90 +26,434 @@ const splitter = "=========================================================\n"
 const insertSQL = "INSERT OR IGNORE INTO searchIndex(name, type, path) VALUES (?,?,?)"
 
 var silent bool
-var docsetDir string
+var dryRun bool
+var jobs int
+var keepBackup bool
+var incremental bool
+var watchMode bool
+var archive bool
+var archiveURL string
+var docsetVersion string
 
 func main() {
-	name, icon := parseFlag()
-	docsetDir = name + ".docset"
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		err := serve(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		err := search(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// icon
-	err := writeIcon(icon)
+	flags, setFlags, configPath := parseFlag()
+	configs, err := loadDocsets(configPath, flags, setFlags)
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
 	}
-
-	// plist
-	err = genPlist(name)
+	groups, err := newDocsetGroups(configs)
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
 	}
 
-	// DB
-	db, err := createDB()
+	// exit with a failure status once godoc is stopped, when the docsets
+	// failed to generate or the watch stopped on an error
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	// stop fetching on interrupt, and exit right away on a second one
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer stopGroups(groups)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		fmt.Println("interrupted, stopping (interrupt again to exit now)")
+		cancel()
+		<-signals
+		fmt.Println("interrupted again, exiting")
+		os.Exit(130)
+	}()
+
+	// generate pages and insert DB indexes
+	err = generateDocsets(ctx, groups)
+	if err == context.Canceled {
+		exitCode = 130
+		return
+	}
 	if err != nil {
 		fmt.Println(err)
+		if !watchMode {
+			exitCode = 1
+			return
+		}
+	}
+	if !watchMode || dryRun {
 		return
 	}
-	defer db.Close()
 
-	// godoc
-	cmd, host, err := runGodoc()
+	// regenerate the changed packages until interrupted
+	watcher, err := newSourceWatcher(watchedDirs(groups))
 	if err != nil {
 		fmt.Println(err)
+		exitCode = 1
 		return
 	}
-	defer func() {
-		printf("killing godoc on %s\n", host)
-		err = cmd.Process.Kill()
+	defer watcher.Close()
+	for ctx.Err() == nil {
+		printf("watching %d directories for changes\n", watcher.Len())
+		var changed []string
+		changed, err = watcher.Wait(ctx)
+		if err != nil {
+			if err != context.Canceled {
+				fmt.Println(err)
+				exitCode = 1
+			}
+			return
+		}
+		printf("%s changed, regenerating\n", strings.Join(changed, ", "))
+
+		err = updateDocsets(ctx, groups, changed)
+		if err != nil && err != context.Canceled {
+			fmt.Println(err)
+		}
+		err = watcher.Add(watchedDirs(groups)...)
 		if err != nil {
-			fmt.Printf("error killing godoc on %s: %s\n", host, err.Error())
+			fmt.Println(err)
 		}
-	}()
+	}
+}
 
-	// get package list
-	packages, err := getPackages(host)
+// docsetGroup is a set of docsets with the same sources, generated from a
+// single package scan and godoc server.
+type docsetGroup struct {
+	docsets []*docset
+
+	// packages holds the selected packages by directory, and roots the
+	// source roots they are found in, to watch them with -watch.
+	packages map[string]localPackage
+	roots    []string
+
+	// source holds the source settings of the docsets.
+	source *docsetSource
+
+	// host is the godoc or pkgsite server the pages are scraped from, and
+	// server the godoc spawned for it, kept running with -watch.
+	host   string
+	server *godocServer
+
+	// generated reports whether the docsets were generated successfully,
+	// so they can be updated in place.
+	generated bool
+}
+
+// newDocsetGroups groups the docsets by sources.
+func newDocsetGroups(configs []docsetConfig) (groups []*docsetGroup, err error) {
+	keys := map[string]*docsetGroup{}
+	outputDirs := map[string]bool{}
+	for _, c := range configs {
+		var d *docset
+		d, err = newDocset(c)
+		if err != nil {
+			return
+		}
+		if outputDirs[d.outputDir] {
+			err = fmt.Errorf("docset %s is described twice", d.outputDir)
+			return
+		}
+		outputDirs[d.outputDir] = true
+
+		key := c.sourceKey()
+		g, ok := keys[key]
+		if !ok {
+			g = &docsetGroup{}
+			g.source, err = newDocsetSource(c)
+			if err != nil {
+				return
+			}
+			keys[key] = g
+			groups = append(groups, g)
+		}
+		d.source = g.source
+		g.docsets = append(g.docsets, d)
+	}
+	return
+}
+
+// stopGroups stops the godoc servers spawned for the groups.
+func stopGroups(groups []*docsetGroup) {
+	for _, g := range groups {
+		g.stop()
+	}
+}
+
+func (g *docsetGroup) stop() {
+	if g.server != nil {
+		g.server.stop()
+		g.server = nil
+		g.host = ""
+	}
+}
+
+// generateDocsets generates the docsets, scanning the packages and running
+// godoc once for the docsets sharing the same sources.
+func generateDocsets(ctx context.Context, groups []*docsetGroup) (err error) {
+	err = loadStdPackages()
 	if err != nil {
-		fmt.Println(err)
 		return
 	}
 
-	// download static resources like css and js
-	grabLib(host)
+	for _, g := range groups {
+		err = g.generate(ctx, incremental)
+		// godoc is kept running to regenerate the changed packages
+		if !watchMode {
+			g.stop()
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// generate scans the packages of the group and generates its docsets,
+// updating the previous ones when incremental.
+func (g *docsetGroup) generate(ctx context.Context, incremental bool) (err error) {
+	for _, d := range g.docsets {
+		d.reset()
+	}
+	g.packages = map[string]localPackage{}
+	g.roots = g.source.roots()
+	g.generated = false
+
+	if g.source.pkgsite {
+		err = generateFromPkgsite(ctx, g, incremental)
+	} else if g.source.godoc {
+		err = generateFromGodoc(ctx, g, incremental)
+	} else {
+		err = generateFromSource(ctx, g, incremental)
+	}
+	g.generated = err == nil && !dryRun
+	return
+}
+
+// selectPackage adds the package to the docsets whose filter it matches,
+// and reports whether any did.
+func (g *docsetGroup) selectPackage(pkg localPackage) bool {
+	keep := false
+	for _, d := range g.docsets {
+		if d.add(pkg.ImportPath) {
+			keep = true
+		}
+	}
+	if keep && pkg.Dir != "" {
+		g.packages[pkg.Dir] = pkg
+	}
+	return keep
+}
 
-	// prepare
-	stmt, err := db.Prepare(insertSQL)
+// generateFromSource renders the docs of $GOPATH or module packages
+// in-process with go/doc, without any godoc server.
+func generateFromSource(ctx context.Context, g *docsetGroup, incremental bool) (err error) {
+	packages, err := g.source.listPackages()
 	if err != nil {
-		fmt.Println(err)
 		return
 	}
-	defer stmt.Close()
-	// transaction
-	tx, err := db.Begin()
+
+	var selected []localPackage
+	for _, pkg := range packages {
+		if g.selectPackage(pkg) {
+			selected = append(selected, pkg)
+		}
+	}
+	if dryRun {
+		printSelected(g.docsets)
+		return
+	}
+
+	err = createDocsets(ctx, g.docsets, incremental, func() error {
+		p := newPool(ctx, jobs)
+		renderPackages(p, g.docsets, selected)
+		p.Wait()
+		return nil
+	})
+	return
+}
+
+// listPackages returns the standard, module or $GOPATH packages according
+// to the source settings.
+func (s *docsetSource) listPackages() (packages []localPackage, err error) {
+	if s.std != "" {
+		packages, err = listStdPackages()
+		if err != nil {
+			return
+		}
+	}
+	if s.std != "only" {
+		var ownPackages []localPackage
+		if s.module != "" {
+			ownPackages, err = s.listModulePackages()
+		} else {
+			ownPackages, err = s.listLocalPackages()
+		}
+		if err != nil {
+			return
+		}
+		packages = append(packages, ownPackages...)
+	}
+	return
+}
+
+// generateFromGodoc scrapes the pages of the -server, or of a spawned godoc
+// server.
+func generateFromGodoc(ctx context.Context, g *docsetGroup, incremental bool) (err error) {
+	if g.source.server != "" {
+		g.host = g.source.server
+	}
+	if g.host == "" {
+		g.server, err = runGodoc(ctx, g.source.env)
+		if err != nil {
+			return
+		}
+		g.host = g.server.host
+	}
+	docsets := g.docsets
+
+	// get package list
+	packages, err := g.getPackages(ctx)
 	if err != nil {
-		fmt.Println(err)
 		return
 	}
-	defer tx.Commit()
 
-	// download pages and insert DB indexes
-	grabPackages(tx.Stmt(stmt), host, packages)
+	var selected []string
+	for _, packageName := range packages {
+		pkg := localPackage{ImportPath: strings.TrimRight(packageName, "/")}
+		bp, err := g.source.build.Import(pkg.ImportPath, "", build.FindOnly)
+		if err == nil {
+			pkg.Dir = bp.Dir
+		}
+		if g.selectPackage(pkg) {
+			selected = append(selected, packageName)
+		}
+	}
+	if dryRun {
+		printSelected(docsets)
+		return
+	}
+
+	err = createDocsets(ctx, docsets, incremental, func() error {
+		p := newPool(ctx, jobs)
+
+		// download static resources like css and js
+		g.grabLib(ctx, p)
+
+		// download pages and insert DB indexes
+		g.grabPackages(ctx, p, selected)
+
+		p.Wait()
+		// the pages fetched after godoc died are missing
+		if g.server != nil {
+			return g.server.exitErr()
+		}
+		return nil
+	})
+	return
 }
 
-func parseFlag() (name string, icon string) {
+// parseFlag returns the docset described by the flags, and the names of the
+// flags set explicitly, which override the config file.
+func parseFlag() (flags docsetConfig, setFlags map[string]bool, configPath string) {
+	var includePatterns patternList
+	var excludePatterns patternList
+	var headers patternList
+	configInput := flag.String("config", "", "Config file describing the docsets to generate (default \""+defaultConfigPath+"\" if it exists)")
 	silentInput := flag.Bool("silent", false, "Silent mode (only print error)")
 	nameInput := flag.String("name", "GoDoc", "Set docset name")
 	iconInput := flag.String("icon", "", "Docset icon .png path")
+	outputInput := flag.String("output", "", "Directory the docset is generated in (default current directory)")
+	godocInput := flag.Bool("godoc", false, "Scrape pages from a spawned godoc server instead of rendering them in-process")
+	serverInput := flag.String("server", "", "Scrape pages from this already running godoc server, e.g. \"http://localhost:6060\", instead of spawning one")
+	pkgsiteInput := flag.Bool("pkgsite", false, "With -server, the server is a pkgsite instance, e.g. \"pkgsite -gopath_mode\", and packages are listed like without -server")
+	flag.Var(&headers, "header", "With -server, \"Name: value\" header sent with every request, can be repeated")
+	basicAuthInput := flag.String("basic-auth", "", "With -server, \"user:password\" sent with every request (default $GODOCDASH_BASIC_AUTH)")
+	moduleInput := flag.String("module", "", "Document the Go module in this directory instead of $GOPATH, remaining arguments are package patterns (default \"./...\")")
+	depsInput := flag.Bool("deps", false, "With -module, also document every dependency at the version pinned in go.mod")
+	fallbackInput := flag.String("fallback", "https://pkg.go.dev/", "Base URL for links to packages outside the docset, a \"dash://\" URL searches the identifier in Dash, e.g. \"dash://go:\"")
+	flag.Var(&includePatterns, "include", "Only document packages matching this glob (\"*\" within a path element, \"**\" across elements) or \"re:\" prefixed regexp, can be repeated")
+	flag.Var(&excludePatterns, "exclude", "Do not document packages matching this glob or \"re:\" prefixed regexp, can be repeated")
+	fullTextInput := flag.Bool("fulltext", false, "Also index the package overviews and doc comments for full-text search, in the searchText table of the docset index")
+	typesInput := flag.String("types", "", "Comma separated Dash entry types to index, e.g. \"Package,Type,Function,Method\" (default all)")
+	dryRunInput := flag.Bool("dry-run", false, "Only list the selected packages, without generating the docset")
+	incrementalInput := flag.Bool("incremental", false, "Only regenerate the packages whose sources changed since the previous docset")
+	backupInput := flag.Bool("backup", false, "Keep the previous docset as <name>.docset.bak when replacing it")
+	jobsInput := flag.Int("jobs", 16, "Maximum number of pages, static resources and source files processed concurrently")
+	timeoutInput := flag.Duration("timeout", 30*time.Second, "Timeout of each request to godoc")
+	godocTimeoutInput := flag.Duration("godoc-timeout", 5*time.Minute, "With -godoc, maximum time to wait for the spawned godoc to index the packages")
+	retriesInput := flag.Int("retries", 3, "Number of retries, with exponential backoff, of requests failing transiently")
+	archiveInput := flag.Bool("archive", false, "Also pack the docset into <name>.tgz and write the <name>.xml Dash feed for it")
+	archiveURLInput := flag.String("archive-url", "", "With -archive, base URL the .tgz is served from, written into the feed")
+	versionInput := flag.String("version", "", "With -archive, docset version written into the feed (default from \"git describe --tags\" of -module, or a timestamp)")
+	watchInput := flag.Bool("watch", false, "Keep running and regenerate the packages whose .go files change")
+	stdInput := flag.String("std", "", "Document the standard library of the go command in $PATH, \"only\" for a standard library docset, \"include\" to add it to your packages")
 
 	flag.Parse()
 	silent = *silentInput
-	name = *nameInput
-	icon = *iconInput
+	dryRun = *dryRunInput
+	keepBackup = *backupInput
+	incremental = *incrementalInput
+	watchMode = *watchInput
+	archive = *archiveInput
+	archiveURL = *archiveURLInput
+	docsetVersion = *versionInput
+	jobs = *jobsInput
+	httpClient.Timeout = *timeoutInput
+	godocTimeout = *godocTimeoutInput
+	retries = *retriesInput
+	configPath = *configInput
+	setFlags = map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	flags = docsetConfig{
+		Name:      *nameInput,
+		Icon:      *iconInput,
+		Output:    *outputInput,
+		GOPATH:    build.Default.GOPATH,
+		Module:    *moduleInput,
+		Patterns:  flag.Args(),
+		Deps:      *depsInput,
+		Godoc:     *godocInput,
+		Server:    *serverInput,
+		Pkgsite:   *pkgsiteInput,
+		Std:       *stdInput,
+		Include:   includePatterns,
+		Exclude:   excludePatterns,
+		Fallback:  *fallbackInput,
+		FullText:  *fullTextInput,
+		Header:    headers,
+		BasicAuth: *basicAuthInput,
+	}
+	if *typesInput != "" {
+		flags.Types = strings.Split(*typesInput, ",")
+	}
 	return
 }
 
-func writeIcon(p string) (err error) {
+func writeIcon(dir string, p string) (err error) {
 	var r io.Reader
 	if p == "" {
 		var buf []byte
@@ -130,7 +472,7 @@ func writeIcon(p string) (err error) {
 		r = bufio.NewReader(f)
 	}
 
-	outputPath := filepath.Join(docsetDir, "icon.png")
+	outputPath := filepath.Join(dir, "icon.png")
 	err = os.MkdirAll(filepath.Dir(outputPath), 0755)
 	if err != nil {
 		return
@@ -143,8 +485,8 @@ func writeIcon(p string) (err error) {
 	return
 }
 
-func createDB() (db *sql.DB, err error) {
-	p := filepath.Join(getResourcesDir(), "docSet.dsidx")
+func createDB(dir string) (db *sql.DB, err error) {
+	p := filepath.Join(getResourcesDir(dir), "docSet.dsidx")
 	err = os.MkdirAll(filepath.Dir(p), 0755)
 	if err != nil {
 		return
@@ -168,47 +510,17 @@ func createDB() (db *sql.DB, err error) {
 	return
 }
 
-func runGodoc() (cmd *exec.Cmd, host string, err error) {
-	// get a free port
-	l, err := net.Listen("tcp", ":0")
-	if err != nil {
-		return
-	}
-	addr := l.Addr()
-	l.Close()
-	tcpAddr, ok := addr.(*net.TCPAddr)
-	if !ok {
-		err = errors.New("failed to find a free port: " + addr.String())
-		return
-	}
+func openDB(dir string) (db *sql.DB, err error) {
+	db, err = sql.Open("sqlite3", filepath.Join(getResourcesDir(dir), "docSet.dsidx"))
+	return
+}
 
-	// try running godoc on this port
-	tryHost := "localhost:" + strconv.Itoa(tcpAddr.Port)
-	cmd = exec.Command("godoc", "-http="+tryHost)
-	if !silent {
-		cmd.Stderr = os.Stderr
-		cmd.Stdout = os.Stdout
-	}
-	cmd.Env = os.Environ()
-	err = cmd.Start()
+func (g *docsetGroup) getPackages(ctx context.Context) (packages []string, err error) {
+	buf, err := fetch(ctx, g.host+"/pkg/", g.source.header)
 	if err != nil {
 		return
 	}
-	host = "http://" + tryHost
-
-	// check port is valid now
-	for i := 0; i < 10; i++ {
-		time.Sleep(500 * time.Millisecond)
-		_, err = http.Get(host)
-		if err == nil {
-			break
-		}
-	}
-	return
-}
-
-func getPackages(host string) (packages []string, err error) {
-	doc, err := goquery.NewDocument(host + "/pkg/")
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(buf))
 	if err != nil {
 		return
 	}
@@ -218,9 +530,7 @@ func getPackages(host string) (packages []string, err error) {
 			return
 		}
 
-		// ignore standard packages as there's official go docset already
-		domain := strings.Split(packageName, "/")[0]
-		if !strings.Contains(domain, ".") {
+		if !g.source.keepPackage(packageName) {
 			return
 		}
 
@@ -229,39 +539,60 @@ func getPackages(host string) (packages []string, err error) {
 	return
 }
 
-func grabPackages(stmt *sql.Stmt, host string, packages []string) {
-	wg := &sync.WaitGroup{}
+func (g *docsetGroup) grabPackages(ctx context.Context, p *pool, packages []string) {
 	for _, packageName := range packages {
-		wg.Add(1)
-		go grabPackage(
-			wg,
-			stmt,
-			strings.TrimRight(packageName, "/"),
-			host+"/pkg/"+packageName,
-		)
+		packageName := packageName
+		p.Go(func() {
+			g.grabPackage(ctx, packageName)
+		})
 	}
-
-	wg.Wait()
 	return
 }
 
-func grabPackage(wg *sync.WaitGroup, stmt *sql.Stmt, packageName string, url string) {
-	defer wg.Done()
+// grabPackage downloads a package page and its source files once, and
+// writes them into every docset the package belongs to.
+func (g *docsetGroup) grabPackage(ctx context.Context, packageName string) {
+	// find the sources of the package to fingerprint it
+	pkg := localPackage{ImportPath: strings.TrimRight(packageName, "/")}
+	bp, err := g.source.build.Import(pkg.ImportPath, "", build.FindOnly)
+	if err == nil {
+		pkg.Dir = bp.Dir
+	}
+
+	cache := newPageCache(ctx, g.host, g.source.header)
+	g.writePackage(pkg, func(d *docset) *packageInfo {
+		return grabPackagePage(d, cache, pkg.ImportPath, "pkg/"+packageName)
+	})
+}
 
-	info := &packageInfo{Name: packageName}
-	defer info.Print()
+// writePackage calls write for every docset the package belongs to, and
+// records the result in their manifest.
+func (g *docsetGroup) writePackage(pkg localPackage, write func(d *docset) *packageInfo) {
+	printed := false
+	for _, d := range g.docsets {
+		if !d.packages[pkg.ImportPath] {
+			continue
+		}
+		d.manifest.Update(pkg, func() error {
+			info := write(d)
+			// print the indexes once, and every error
+			if !printed || info.Err != nil {
+				info.Print()
+				printed = true
+			}
+			return info.Err
+		})
+	}
+}
 
+func grabPackagePage(d *docset, cache *pageCache, packageName string, relPath string) (info *packageInfo) {
+	info = &packageInfo{Name: packageName}
 	var err error
 	defer func() {
 		info.Err = err
 	}()
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
-	buf, err := ioutil.ReadAll(resp.Body)
+	buf, err := cache.fetch(relPath)
 	if err != nil {
 		return
 	}
@@ -272,53 +603,83 @@ func grabPackage(wg *sync.WaitGroup, stmt *sql.Stmt, packageName string, url str
 
 	// skip directories
 	info.Parse(doc)
-	if info.Err != nil {
-		return
-	}
 	if info.IsEmpty() {
 		return
 	}
 
+	info.ParseText(doc)
+	info.AddDashAnchors(doc)
 	documentPath := getDocumentPath(info.Name)
 	replaceLinks(doc, documentPath)
+	d.replacePackageLinks(doc, documentPath, "/pkg/")
+	sources := replaceSourceLinks(doc, documentPath, info.Name)
 	newHTML, err := goquery.OuterHtml(doc.Selection)
 	if err != nil {
 		return
 	}
 
-	err = writeFile(documentPath, strings.NewReader(newHTML))
+	err = writeFile(d.dir, documentPath, strings.NewReader(newHTML))
 	if err != nil {
 		return
 	}
 
-	err = info.WriteInsert(stmt)
+	for _, src := range sources {
+		err = grabSource(d, cache, src)
+		if err != nil {
+			return
+		}
+	}
+
+	err = d.insert(info)
+	return
+}
+
+// pageCache fetches each page from godoc once, as several docsets may need
+// it. It is not safe for concurrent use.
+type pageCache struct {
+	ctx    context.Context
+	host   string
+	header http.Header
+	pages  map[string][]byte
+	errs   map[string]error
 }
 
-func grabLib(host string) {
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	grabDirectory(wg, host, "lib/godoc/")
-	wg.Wait()
+func newPageCache(ctx context.Context, host string, header http.Header) *pageCache {
+	return &pageCache{
+		ctx:    ctx,
+		host:   host,
+		header: header,
+		pages:  map[string][]byte{},
+		errs:   map[string]error{},
+	}
+}
+
+func (cache *pageCache) fetch(relPath string) (buf []byte, err error) {
+	if buf, ok := cache.pages[relPath]; ok {
+		return buf, cache.errs[relPath]
+	}
+	buf, err = fetch(cache.ctx, cache.host+"/"+relPath, cache.header)
+	cache.pages[relPath] = buf
+	cache.errs[relPath] = err
 	return
 }
 
-func grabDirectory(wg *sync.WaitGroup, host string, relPath string) {
-	defer wg.Done()
+func (g *docsetGroup) grabLib(ctx context.Context, p *pool) {
+	p.Go(func() {
+		g.grabDirectory(ctx, p, "lib/godoc/")
+	})
+	return
+}
 
+func (g *docsetGroup) grabDirectory(ctx context.Context, p *pool, relPath string) {
 	// Avoid visiting entries in godoc html template it self,
 	// e.g. entries in /lib/godoc/codewalkdir.html
 	if strings.Contains(relPath, "{{") {
 		return
 	}
 
-	url := host + "/" + relPath
-	resp, err := http.Get(url)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	defer resp.Body.Close()
-	buf, err := ioutil.ReadAll(resp.Body)
+	url := g.host + "/" + relPath
+	buf, err := fetch(ctx, url, g.source.header)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -341,27 +702,31 @@ func grabDirectory(wg *sync.WaitGroup, host string, relPath string) {
 
 		// download css and js
 		if strings.HasSuffix(href, ".css") || strings.HasSuffix(href, ".js") {
-			url := host + "/" + relPath + href
-			res, err := http.Get(url)
-			if err != nil {
-				fmt.Println(err)
-			}
-			defer res.Body.Close()
-			err = writeFile(relPath+href, res.Body)
-			if err != nil {
-				fmt.Println(err)
-			}
+			p.Go(func() {
+				buf, err := fetch(ctx, g.host+"/"+relPath+href, g.source.header)
+				if err != nil {
+					fmt.Println(err)
+					return
+				}
+				for _, d := range g.docsets {
+					err = writeFile(d.dir, relPath+href, bytes.NewReader(buf))
+					if err != nil {
+						fmt.Println(err)
+					}
+				}
+			})
 			return
 		}
 		// or walk into next directory
-		wg.Add(1)
-		go grabDirectory(wg, host, relPath+href)
+		p.Go(func() {
+			g.grabDirectory(ctx, p, relPath+href)
+		})
 	})
 	return
 }
 
-func genPlist(docsetName string) (err error) {
-	contentsDir := getContentsDir()
+func genPlist(dir string, docsetName string) (err error) {
+	contentsDir := getContentsDir(dir)
 	err = os.MkdirAll(contentsDir, 0755)
 	if err != nil {
 		return
@@ -385,6 +750,8 @@ func genPlist(docsetName string) (err error) {
 	<string>%s</string>
 	<key>isDashDocset</key>
 	<true/>
+	<key>DashDocSetFamily</key>
+	<string>dashtoc</string>
 </dict>
 </plist>`,
 		docsetName,
@@ -432,8 +799,59 @@ func replaceLinks(doc *goquery.Document, documentPath string) {
 	})
 }
 
-func writeFile(relPath string, r io.Reader) (err error) {
-	p := filepath.Join(getResourcesDir(), "Documents", relPath)
+// replacePackageLinks rewrites the links to package pages starting with
+// prefix, like "/pkg/github.com/foo/bar/#Thing" for godoc, to a relative path
+// when the package is in the docset, or to the fallback URL otherwise.
+func (d *docset) replacePackageLinks(doc *goquery.Document, documentPath string, prefix string) {
+	dir := path.Dir(documentPath)
+
+	doc.Find(`a[href^="` + prefix + `"]`).Each(func(index int, selection *goquery.Selection) {
+		href, _ := selection.Attr("href")
+		// protocol relative URLs
+		if strings.HasPrefix(href, "//") {
+			return
+		}
+		fragment := ""
+		if i := strings.Index(href, "#"); i >= 0 {
+			href, fragment = href[:i], href[i:]
+		}
+		if i := strings.Index(href, "?"); i >= 0 {
+			href = href[:i]
+		}
+		packageName := strings.Trim(strings.TrimPrefix(href, prefix), "/")
+		newHref, err := d.packageHref(dir, packageName, fragment)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		selection.SetAttr("href", newHref)
+	})
+}
+
+// packageHref returns the link to a package from a page in dir, a relative
+// path when the package is in the docset, or the fallback URL otherwise.
+func (d *docset) packageHref(dir string, packageName string, fragment string) (href string, err error) {
+	if d.packages[packageName] {
+		href, err = filepath.Rel(dir, getDocumentPath(packageName))
+		href = filepath.ToSlash(href) + fragment
+		return
+	}
+
+	fallbackURL := d.config.Fallback
+	if strings.HasPrefix(fallbackURL, "dash://") {
+		query := packageName
+		if fragment != "" {
+			query += "." + fragment[1:]
+		}
+		href = fallbackURL + query
+		return
+	}
+	href = fallbackURL + packageName + fragment
+	return
+}
+
+func writeFile(dir string, relPath string, r io.Reader) (err error) {
+	p := filepath.Join(getResourcesDir(dir), "Documents", relPath)
 	err = os.MkdirAll(filepath.Dir(p), 0755)
 	if err != nil {
 		return
@@ -449,18 +867,52 @@ func writeFile(relPath string, r io.Reader) (err error) {
 	return
 }
 
-func getResourcesDir() string {
-	return filepath.Join(getContentsDir(), "Resources")
+func getResourcesDir(dir string) string {
+	return filepath.Join(getContentsDir(dir), "Resources")
 }
 
-func getContentsDir() string {
-	return filepath.Join(docsetDir, "Contents")
+func getContentsDir(dir string) string {
+	return filepath.Join(dir, "Contents")
 }
 
 func getDocumentPath(packageName string) string {
 	return path.Join("pkg", packageName, "index.html")
 }
 
+func getSourcePath(src string) string {
+	return src + ".html"
+}
+
+// keepPackage reports whether a package belongs to the docset according to
+// -std. By default standard packages are ignored as there's official go
+// docset already, and with "-std only" the other packages are ignored.
+func (s *docsetSource) keepPackage(importPath string) bool {
+	if !isStandardPackage(importPath) {
+		return s.std != "only"
+	}
+	if s.std == "" {
+		return false
+	}
+	return !isInternalPackage(importPath)
+}
+
+// isInternalPackage reports whether the import path has an "internal" or
+// "vendor" element, which are not part of the public API.
+func isInternalPackage(importPath string) bool {
+	for _, elem := range strings.Split(strings.Trim(importPath, "/"), "/") {
+		if elem == "internal" || elem == "vendor" {
+			return true
+		}
+	}
+	return false
+}
+
+// isStandardPackage reports whether the import path is a package or a
+// directory of the standard library, as listed by loadStdPackages.
+func isStandardPackage(importPath string) bool {
+	return stdPackages[strings.Trim(importPath, "/")]
+}
+
 func printf(format string, a ...interface{}) {
 	if !silent {
 		fmt.Printf(format, a...)
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestReplacePackageLinks(t *testing.T) {
+	tests := []struct {
+		fallback string
+		href     string
+		want     string
+	}{
+		{"https://godoc.org/", "/pkg/github.com/foo/bar/", "../bar/index.html"},
+		{"https://godoc.org/", "/pkg/github.com/foo/bar/#Thing", "../bar/index.html#Thing"},
+		{"https://godoc.org/", "/pkg/github.com/foo/bar/?m=all#Thing", "../bar/index.html#Thing"},
+		{"https://godoc.org/", "/pkg/github.com/foo/baz/sub/", "sub/index.html"},
+		{"https://godoc.org/", "/pkg/fmt/", "https://godoc.org/fmt"},
+		{"https://godoc.org/", "/pkg/fmt/#Println", "https://godoc.org/fmt#Println"},
+		{"https://godoc.org/", "/pkg/fmt/?m=all#Println", "https://godoc.org/fmt#Println"},
+		{"dash://go:", "/pkg/fmt/", "dash://go:fmt"},
+		{"dash://go:", "/pkg/fmt/#Println", "dash://go:fmt.Println"},
+		{"dash://go:", "/pkg/github.com/foo/bar/#Thing", "../bar/index.html#Thing"},
+		{"https://godoc.org/", "//example.com/pkg/fmt/", "//example.com/pkg/fmt/"},
+		{"https://godoc.org/", "/src/fmt/print.go", "/src/fmt/print.go"},
+	}
+	for _, test := range tests {
+		d := &docset{
+			config: docsetConfig{Fallback: test.fallback},
+			packages: map[string]bool{
+				"github.com/foo/bar":     true,
+				"github.com/foo/baz":     true,
+				"github.com/foo/baz/sub": true,
+			},
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<a href="` + test.href + `">link</a>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.replacePackageLinks(doc, getDocumentPath("github.com/foo/baz"), "/pkg/")
+		if got, _ := doc.Find("a").Attr("href"); got != test.want {
+			t.Errorf("%s with fallback %s: href = %q, want %q", test.href, test.fallback, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// manifestVersion is part of the options fingerprint, so that docsets
+// generated by an incompatible version are fully regenerated.
+const manifestVersion = "8"
+
+// manifest records the fingerprint of every package generated into a
+// docset, so that the next run can only regenerate the changed ones.
+type manifest struct {
+	Options  string
+	Packages map[string]string
+
+	mu       sync.Mutex
+	dir      string
+	tx       *sql.Tx
+	fullText bool
+	previous map[string]string
+	// failed lists the packages whose generation failed.
+	failed []string
+}
+
+// newManifest returns the manifest of the docset staged in dir.
+func newManifest(dir string, options string) *manifest {
+	return &manifest{
+		dir:      dir,
+		Options:  manifestVersion + " " + options,
+		Packages: map[string]string{},
+		previous: map[string]string{},
+	}
+}
+
+func getManifestPath(dir string) string {
+	return filepath.Join(dir, "Contents", "Resources", "godocdash.json")
+}
+
+// loadPrevious reads the manifest of the docset in dir, and reports whether
+// it was generated with the same options, so it can be updated.
+func (m *manifest) loadPrevious(dir string) bool {
+	buf, err := ioutil.ReadFile(getManifestPath(dir))
+	if err != nil {
+		return false
+	}
+	previous := &manifest{}
+	err = json.Unmarshal(buf, previous)
+	if err != nil || previous.Options != m.Options {
+		return false
+	}
+	m.previous = previous.Packages
+	return true
+}
+
+// loadCurrent reads the manifest of the docset in its own directory, to
+// update it in place.
+func (m *manifest) loadCurrent() (err error) {
+	buf, err := ioutil.ReadFile(getManifestPath(m.dir))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(buf, m)
+	if err != nil {
+		return
+	}
+	for packageName, fingerprint := range m.Packages {
+		m.previous[packageName] = fingerprint
+	}
+	return
+}
+
+func (m *manifest) write() (err error) {
+	buf, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(getManifestPath(m.dir), buf, 0644)
+	return
+}
+
+// Update calls generate for a package when its fingerprint changed since the
+// previous docset, after removing its previous pages and indexes. The
+// fingerprint is recorded when generate succeeds.
+func (m *manifest) Update(pkg localPackage, generate func() error) {
+	fingerprint, err := packageFingerprint(pkg)
+	if err != nil {
+		fingerprint = ""
+	}
+
+	m.mu.Lock()
+	previous, ok := m.previous[pkg.ImportPath]
+	m.mu.Unlock()
+	if ok && fingerprint != "" && previous == fingerprint {
+		printf("\n%s is unchanged, skip\n\n"+splitter, pkg.ImportPath)
+		m.set(pkg.ImportPath, fingerprint)
+		return
+	}
+	if ok {
+		err = m.remove(pkg.ImportPath)
+		if err != nil {
+			printf("\n%s error: %s\n\n"+splitter, pkg.ImportPath, err.Error())
+			m.fail(pkg.ImportPath)
+			return
+		}
+	}
+
+	if generate() != nil {
+		m.fail(pkg.ImportPath)
+		return
+	}
+	if fingerprint != "" {
+		m.set(pkg.ImportPath, fingerprint)
+	}
+}
+
+// fail records a package whose generation failed, its previous pages and
+// indexes being removed already.
+func (m *manifest) fail(packageName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.Packages, packageName)
+	m.failed = append(m.failed, packageName)
+}
+
+// Delete removes the pages and indexes of a package which is not part of
+// the docset anymore.
+func (m *manifest) Delete(packageName string) {
+	printf("\n%s is removed\n\n"+splitter, packageName)
+	err := m.remove(packageName)
+	if err != nil {
+		printf("\n%s error: %s\n\n"+splitter, packageName, err.Error())
+		m.fail(packageName)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.Packages, packageName)
+}
+
+func (m *manifest) set(packageName string, fingerprint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Packages[packageName] = fingerprint
+}
+
+// Prune removes the pages and indexes of the packages of the previous
+// docset which are not part of the docset anymore.
+func (m *manifest) Prune() (err error) {
+	for packageName := range m.previous {
+		if _, ok := m.Packages[packageName]; ok {
+			continue
+		}
+		printf("\n%s is removed\n\n"+splitter, packageName)
+		err = m.remove(packageName)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// remove deletes the indexes, the page and the source files of a package.
+func (m *manifest) remove(packageName string) (err error) {
+	documentPath := getDocumentPath(packageName)
+	tables := []string{"searchIndex"}
+	if m.fullText {
+		tables = append(tables, "searchText")
+	}
+	for _, table := range tables {
+		_, err = m.tx.Exec(
+			"DELETE FROM "+table+" WHERE path = ? OR substr(path, 1, ?) = ?",
+			documentPath, len(documentPath)+1, documentPath+"#",
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	documentsDir := filepath.Join(getResourcesDir(m.dir), "Documents")
+	err = os.Remove(filepath.Join(documentsDir, documentPath))
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	err = nil
+	sources, err := filepath.Glob(filepath.Join(documentsDir, getSourcePath(filepath.Join("src", packageName, "*.go"))))
+	if err != nil {
+		return
+	}
+	for _, source := range sources {
+		err = os.Remove(source)
+		if err != nil {
+			return
+		}
+	}
+
+	// the directories are left when they still contain subpackages
+	os.Remove(filepath.Join(documentsDir, "src", packageName))
+	os.Remove(filepath.Dir(filepath.Join(documentsDir, documentPath)))
+	return
+}
+
+// packageFingerprint returns the module version of a package from the
+// module cache, or a hash of its Go files.
+func packageFingerprint(pkg localPackage) (fingerprint string, err error) {
+	if pkg.Version != "" {
+		fingerprint = "module " + pkg.Version
+		return
+	}
+	// packages of a remote server may not be found locally
+	if pkg.Dir == "" {
+		return
+	}
+
+	bp, err := build.Default.ImportDir(pkg.Dir, 0)
+	if err != nil {
+		return
+	}
+	var files []string
+	for _, list := range [][]string{bp.GoFiles, bp.CgoFiles, bp.TestGoFiles, bp.XTestGoFiles} {
+		files = append(files, list...)
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, name := range files {
+		var f *os.File
+		f, err = os.Open(filepath.Join(pkg.Dir, name))
+		if err != nil {
+			return
+		}
+		io.WriteString(h, name+"\n")
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+	fingerprint = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// copyDir copies the files of the src directory tree into dst.
+func copyDir(src string, dst string) error {
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		r, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		w, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		_, err = io.Copy(w, r)
+		return err
+	})
+}
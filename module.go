@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type goModule struct {
+	Path    string
+	Version string
+	Main    bool
+	Dir     string
+	Replace *goModule
+}
+
+type goPackage struct {
+	ImportPath string
+	Dir        string
+	Name       string
+	Standard   bool
+}
+
+// listModulePackages returns the packages of the main module matching the
+// patterns, and with -deps, every package of the modules it depends on at
+// the version selected by go.mod.
+func (s *docsetSource) listModulePackages() (packages []localPackage, err error) {
+	patterns := s.patterns
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	out, err := runGo(s.env, s.module, append([]string{"list", "-e", "-json"}, patterns...)...)
+	if err != nil {
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var p goPackage
+		err = dec.Decode(&p)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		if p.Standard || p.Name == "" || p.Dir == "" {
+			continue
+		}
+		packages = append(packages, localPackage{
+			ImportPath: p.ImportPath,
+			Dir:        p.Dir,
+		})
+	}
+
+	if !s.deps {
+		return
+	}
+
+	modules, err := s.listDependencies()
+	if err != nil {
+		return
+	}
+	for _, mod := range modules {
+		var modPackages []localPackage
+		modPackages, err = walkModule(mod)
+		if err != nil {
+			return
+		}
+		packages = append(packages, modPackages...)
+	}
+	return
+}
+
+// listStdPackages returns the public packages of the standard library of
+// the go command in $PATH.
+func listStdPackages() (packages []localPackage, err error) {
+	out, err := runGo(nil, "", "list", "-e", "-json", "std")
+	if err != nil {
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var p goPackage
+		err = dec.Decode(&p)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		if p.Name == "" || p.Dir == "" || isInternalPackage(p.ImportPath) {
+			continue
+		}
+		packages = append(packages, localPackage{
+			ImportPath: p.ImportPath,
+			Dir:        p.Dir,
+		})
+	}
+	return
+}
+
+// stdPackages holds the import paths of the standard library packages of
+// the go command in $PATH, and of the directories containing them.
+var stdPackages map[string]bool
+
+// loadStdPackages lists the standard library packages once.
+func loadStdPackages() (err error) {
+	if stdPackages != nil {
+		return
+	}
+	out, err := runGo(nil, "", "list", "-e", "std")
+	if err != nil {
+		return
+	}
+	packages := map[string]bool{}
+	for _, importPath := range strings.Fields(string(out)) {
+		for p := importPath; p != "." && !packages[p]; p = path.Dir(p) {
+			packages[p] = true
+		}
+	}
+	stdPackages = packages
+	return
+}
+
+// listDependencies resolves the module graph of the main module, and makes
+// sure every dependency is present in the module cache.
+func (s *docsetSource) listDependencies() (modules []goModule, err error) {
+	dir := s.module
+	out, err := runGo(s.env, dir, "list", "-m", "-json", "all")
+	if err != nil {
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var mod goModule
+		err = dec.Decode(&mod)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		if mod.Main {
+			continue
+		}
+		if mod.Replace != nil {
+			mod.Dir = mod.Replace.Dir
+			if mod.Replace.Version != "" {
+				mod.Version = mod.Replace.Version
+			}
+			if mod.Dir != "" && !filepath.IsAbs(mod.Dir) {
+				mod.Dir = filepath.Join(dir, mod.Dir)
+			}
+		}
+		if mod.Dir == "" {
+			mod.Dir, err = s.downloadModule(mod)
+			if err != nil {
+				return
+			}
+		}
+		modules = append(modules, mod)
+	}
+	return
+}
+
+func (s *docsetSource) downloadModule(mod goModule) (modDir string, err error) {
+	query := mod.Path + "@" + mod.Version
+	if mod.Replace != nil {
+		query = mod.Replace.Path + "@" + mod.Replace.Version
+	}
+	printf("downloading %s\n", query)
+	out, err := runGo(s.env, s.module, "mod", "download", "-json", query)
+	if err != nil {
+		return
+	}
+	var downloaded goModule
+	err = json.Unmarshal(out, &downloaded)
+	if err != nil {
+		return
+	}
+	modDir = downloaded.Dir
+	return
+}
+
+// walkModule returns the packages inside a module directory, skipping
+// nested modules.
+func walkModule(mod goModule) (packages []localPackage, err error) {
+	// modules replaced by a local directory have no version to rely on
+	version := ""
+	if mod.Replace == nil || mod.Replace.Version != "" {
+		version = mod.Path + "@" + mod.Version
+	}
+	err = filepath.Walk(mod.Dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if p != mod.Dir {
+			if isIgnoredDir(fi.Name()) {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(p, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+		}
+		rel, err := filepath.Rel(mod.Dir, p)
+		if err != nil {
+			return nil
+		}
+		if _, err := build.Default.ImportDir(p, 0); err != nil {
+			return nil
+		}
+		packages = append(packages, localPackage{
+			ImportPath: path.Join(mod.Path, filepath.ToSlash(rel)),
+			Dir:        p,
+			Version:    version,
+		})
+		return nil
+	})
+	return
+}
+
+// runGo runs the go command in dir, with the environment env or the current
+// one if nil.
+func runGo(env []string, dir string, args ...string) (out []byte, err error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err = cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("go %s: %s: %s", strings.Join(args, " "), err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return
+}
@@ -3,6 +3,12 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"net/url"
+	"regexp"
 	"strings"
 	"sync"
 
@@ -17,6 +23,32 @@ var parseFuncSelectors = []string{
 type packageIndex struct {
 	Name string
 	Path string
+	// Kind overrides the Dash entry type of the slice the index belongs to,
+	// e.g. "Struct" or "Interface" for a type.
+	Kind string
+	// Alias reports whether a type is an alias, as Dash has no entry type
+	// for them. It is named with the aliasSuffix.
+	Alias bool
+}
+
+const aliasSuffix = " (alias)"
+
+// displayName returns the name of the index, with the aliasSuffix for
+// type aliases.
+func (index packageIndex) displayName() string {
+	if index.Alias {
+		return index.Name + aliasSuffix
+	}
+	return index.Name
+}
+
+// entryType returns the Dash entry type of the index, defaulting to the type
+// of the slice it belongs to.
+func (index packageIndex) entryType(typeName string) string {
+	if index.Kind != "" {
+		return index.Kind
+	}
+	return typeName
 }
 
 type packageInfo struct {
@@ -25,7 +57,17 @@ type packageInfo struct {
 	Consts    []packageIndex
 	Variables []packageIndex
 	Funcs     []packageIndex
+	Methods   []packageIndex
 	Types     []packageIndex
+	Fields    []packageIndex
+	Samples   []packageIndex
+	Sections  []packageIndex
+	// Texts holds the documentation prose for the full-text index.
+	Texts []packageText
+	// valueCommentsFollow reports whether the comments of constant and
+	// variable declarations follow them, like on the pages rendered
+	// in-process, instead of preceding them like on godoc pages.
+	valueCommentsFollow bool
 }
 
 func (info *packageInfo) Print() {
@@ -41,13 +83,21 @@ func (info *packageInfo) Print() {
 %s contains:
 +	const: %+v
 +	func: %+v
++	method: %+v
 +	type: %+v
++	field: %+v
++	sample: %+v
++	section: %+v
 
 `+splitter,
 		info.Name,
 		info.Consts,
 		info.Funcs,
+		info.Methods,
 		info.Types,
+		info.Fields,
+		info.Samples,
+		info.Sections,
 	)
 	return
 }
@@ -56,7 +106,10 @@ func (info *packageInfo) IsEmpty() bool {
 	return (len(info.Consts) +
 		len(info.Variables) +
 		len(info.Funcs) +
-		len(info.Types)) <= 0
+		len(info.Methods) +
+		len(info.Types) +
+		len(info.Fields) +
+		len(info.Samples)) <= 0
 }
 
 func (info *packageInfo) Parse(doc *goquery.Document) {
@@ -79,6 +132,10 @@ func (info *packageInfo) Parse(doc *goquery.Document) {
 	}()
 
 	wg.Wait()
+
+	info.ParseMembers(doc)
+	info.ParseExamples(doc)
+	info.ParseSections(doc)
 }
 
 func (info *packageInfo) ParseType(doc *goquery.Document) {
@@ -96,13 +153,101 @@ func (info *packageInfo) ParseType(doc *goquery.Document) {
 		if !ok {
 			return
 		}
+		spec := parseTypeSpec(name, typeDecl(selection).Text())
 		info.Types = append(info.Types, packageIndex{
+			Name:  name,
+			Path:  href,
+			Kind:  typeKind(spec),
+			Alias: isAlias(spec),
+		})
+	})
+}
+
+// ParseMembers indexes the exported fields of structs and the methods of
+// interfaces, and anchors them in the type declarations. It modifies the
+// document, so it must not run concurrently with the other parsers.
+func (info *packageInfo) ParseMembers(doc *goquery.Document) {
+	for _, t := range info.Types {
+		if t.Kind != "Struct" && t.Kind != "Interface" {
+			continue
+		}
+		pre := typeDecl(doc.Find("h2#" + t.Name))
+		fields, methods := memberNames(parseTypeSpec(t.Name, pre.Text()))
+		html, err := pre.Html()
+		if err != nil {
+			continue
+		}
+		pre.SetHtml(anchorMembers(html, t.Name, append(fields, methods...)))
+
+		info.Fields = append(info.Fields, memberIndexes(t.Name, fields)...)
+		info.Methods = append(info.Methods, memberIndexes(t.Name, methods)...)
+	}
+}
+
+// ParseExamples indexes the runnable examples, and expands them as the
+// docset viewer can't run the toggles. It modifies the document, so it must
+// not run concurrently with the other parsers.
+func (info *packageInfo) ParseExamples(doc *goquery.Document) {
+	doc.Find("div.toggle").Each(func(index int, selection *goquery.Selection) {
+		id, ok := selection.Attr("id")
+		if !ok || !strings.HasPrefix(id, "example_") {
+			return
+		}
+		info.Samples = append(info.Samples, exampleIndex(strings.TrimPrefix(id, "example_")))
+		selection.RemoveClass("toggle").AddClass("toggleVisible")
+	})
+}
+
+// ParseSections indexes the headings of the package overview, which godoc
+// and pkgsite anchor with "hdr-" prefixed ids.
+func (info *packageInfo) ParseSections(doc *goquery.Document) {
+	overviewElements(doc).Filter("h3, h4").Each(func(index int, selection *goquery.Selection) {
+		id, ok := selection.Attr("id")
+		if !ok || !strings.HasPrefix(id, "hdr-") {
+			return
+		}
+		// without the permalink of pkgsite
+		name := strings.TrimSuffix(strings.TrimSpace(selection.Text()), "¶")
+		name = strings.Join(strings.Fields(name), " ")
+		if name == "" {
+			return
+		}
+		info.Sections = append(info.Sections, packageIndex{
 			Name: name,
-			Path: href,
+			Path: "#" + id,
 		})
 	})
 }
 
+// overviewElements returns the elements of the package overview of a godoc
+// page, of a page rendered in-process, or of a pkgsite page.
+func overviewElements(doc *goquery.Document) *goquery.Selection {
+	overview := doc.Find("#pkg-overview").First()
+	switch goquery.NodeName(overview) {
+	case "div":
+		return overview.Find(".expanded").First().Children()
+	case "h2":
+		return overview.NextUntil("h2")
+	case "h3":
+		return overview.NextAll()
+	}
+	return overview
+}
+
+// typeDecl returns the declaration block following a type heading. The doc
+// comment may contain preformatted blocks before the declaration.
+func typeDecl(heading *goquery.Selection) (decl *goquery.Selection) {
+	decl = heading.NextFilteredUntil("pre", "h2, h3")
+	decl.EachWithBreak(func(index int, pre *goquery.Selection) bool {
+		if strings.HasPrefix(pre.Text(), "type ") {
+			decl = pre
+			return false
+		}
+		return true
+	})
+	return decl.First()
+}
+
 func (info *packageInfo) ParseFunc(doc *goquery.Document) {
 	for _, selector := range parseFuncSelectors {
 		doc.Find(selector).Each(func(index int, selection *goquery.Selection) {
@@ -120,6 +265,14 @@ func (info *packageInfo) ParseFunc(doc *goquery.Document) {
 				return
 			}
 
+			// Methods are identified as "Type.Method".
+			if strings.Contains(name, ".") {
+				info.Methods = append(info.Methods, packageIndex{
+					Name: name,
+					Path: href,
+				})
+				return
+			}
 			info.Funcs = append(info.Funcs, packageIndex{
 				Name: name,
 				Path: href,
@@ -157,24 +310,361 @@ func (info *packageInfo) ParseConstAndVariable(doc *goquery.Document) {
 	})
 }
 
-func (info *packageInfo) WriteInsert(stmt *sql.Stmt) (err error) {
-	_, err = stmt.Exec(info.Name, "Package", getDocumentPath(info.Name))
+// ParseDoc fills the indexes from the documentation computed by go/doc,
+// using the same anchors as the pages produced by godoc. Examples are only
+// indexed when the identifier they belong to is documented.
+func (info *packageInfo) ParseDoc(p *doc.Package, examples []*doc.Example) {
+	info.Consts = append(info.Consts, valueIndexes(p.Consts)...)
+	info.Variables = append(info.Variables, valueIndexes(p.Vars)...)
+	info.Funcs = append(info.Funcs, funcIndexes(p.Funcs)...)
+	for _, t := range p.Types {
+		spec := findTypeSpec(t.Decl, t.Name)
+		info.Types = append(info.Types, packageIndex{
+			Name:  t.Name,
+			Path:  "#" + t.Name,
+			Kind:  typeKind(spec),
+			Alias: isAlias(spec),
+		})
+		fields, methods := memberNames(spec)
+		info.Fields = append(info.Fields, memberIndexes(t.Name, fields)...)
+		info.Methods = append(info.Methods, memberIndexes(t.Name, methods)...)
+		info.Consts = append(info.Consts, valueIndexes(t.Consts)...)
+		info.Variables = append(info.Variables, valueIndexes(t.Vars)...)
+		info.Funcs = append(info.Funcs, funcIndexes(t.Funcs)...)
+		info.Methods = append(info.Methods, funcIndexes(t.Methods)...)
+	}
+
+	targets := map[string]bool{"": true}
+	for _, indexes := range [][]packageIndex{info.Funcs, info.Methods, info.Types} {
+		for _, index := range indexes {
+			targets[index.Name] = true
+		}
+	}
+	for _, example := range examples {
+		target, _ := splitExampleName(example.Name)
+		if targets[target] {
+			info.Samples = append(info.Samples, exampleIndex(example.Name))
+		}
+	}
+}
+
+// exampleIndex returns the index of an example, named like its function
+// without the "Example" prefix, e.g. "T_Method_suffix".
+func exampleIndex(name string) packageIndex {
+	target, suffix := splitExampleName(name)
+	return packageIndex{
+		Name: exampleLabel(target, suffix),
+		Path: "#example_" + name,
+	}
+}
+
+// exampleLabel names the example of target with suffix, e.g.
+// "T.Method (example second)", or "(example)" for a package example.
+func exampleLabel(target string, suffix string) string {
+	label := "(example)"
+	if suffix != "" {
+		label = "(example " + suffix + ")"
+	}
+	if target != "" {
+		label = target + " " + label
+	}
+	return label
+}
+
+// splitExampleName splits an example name into the identifier it belongs
+// to, e.g. "T.Method", and its lower case suffix.
+func splitExampleName(name string) (target string, suffix string) {
+	parts := strings.Split(name, "_")
+	target = parts[0]
+	i := 1
+	if target != "" && i < len(parts) && parts[i] != "" && ast.IsExported(parts[i]) {
+		target += "." + parts[i]
+		i++
+	}
+	suffix = strings.Join(parts[i:], "_")
+	return
+}
+
+// parseTypeSpec parses the declaration text of a type as shown in a godoc
+// page.
+func parseTypeSpec(name string, decl string) *ast.TypeSpec {
+	f, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+decl, 0)
+	if err != nil {
+		return nil
+	}
+	for _, d := range f.Decls {
+		genDecl, ok := d.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		if spec := findTypeSpec(genDecl, name); spec != nil {
+			return spec
+		}
+	}
+	return nil
+}
+
+func findTypeSpec(decl *ast.GenDecl, name string) *ast.TypeSpec {
+	for _, spec := range decl.Specs {
+		typeSpec, ok := spec.(*ast.TypeSpec)
+		if ok && typeSpec.Name.Name == name {
+			return typeSpec
+		}
+	}
+	return nil
+}
+
+// typeKind returns the Dash entry type of a type declaration: "Struct",
+// "Interface", or "Type" for any other type, including type aliases.
+func typeKind(spec *ast.TypeSpec) string {
+	if spec == nil || isAlias(spec) {
+		return "Type"
+	}
+	switch spec.Type.(type) {
+	case *ast.StructType:
+		return "Struct"
+	case *ast.InterfaceType:
+		return "Interface"
+	}
+	return "Type"
+}
+
+// isAlias reports whether a type declaration is a type alias.
+func isAlias(spec *ast.TypeSpec) bool {
+	return spec != nil && spec.Assign.IsValid()
+}
+
+// memberNames returns the exported field names of a struct type, or the
+// exported method names of an interface type.
+func memberNames(spec *ast.TypeSpec) (fields []string, methods []string) {
+	if spec == nil || spec.Assign.IsValid() {
+		return
+	}
+	switch t := spec.Type.(type) {
+	case *ast.StructType:
+		for _, field := range t.Fields.List {
+			for _, ident := range field.Names {
+				if ident.IsExported() {
+					fields = append(fields, ident.Name)
+				}
+			}
+		}
+	case *ast.InterfaceType:
+		for _, method := range t.Methods.List {
+			if _, ok := method.Type.(*ast.FuncType); !ok {
+				continue
+			}
+			for _, ident := range method.Names {
+				if ident.IsExported() {
+					methods = append(methods, ident.Name)
+				}
+			}
+		}
+	}
+	return
+}
+
+func memberIndexes(typeName string, members []string) (indexes []packageIndex) {
+	for _, member := range members {
+		name := typeName + "." + member
+		indexes = append(indexes, packageIndex{
+			Name: name,
+			Path: "#" + name,
+		})
+	}
+	return
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// anchorMembers prefixes the line declaring each member in the HTML of a
+// type declaration with an empty <span id="Type.Member">, the way godoc
+// anchors struct fields. Only the lines directly within the braces of the
+// type are searched, not the ones of nested struct types. Members already
+// anchored are left untouched.
+func anchorMembers(declHTML string, typeName string, members []string) string {
+	lines := strings.Split(declHTML, "\n")
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = strings.TrimSpace(htmlTag.ReplaceAllString(line, ""))
+	}
+	depths := lineDepths(texts)
+
+	start := -1
+	for i, text := range texts {
+		names := leadingNames(strings.TrimPrefix(text, "type "))
+		if len(names) > 0 && names[0] == typeName {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return declHTML
+	}
+	depth := depths[start] + 1
+
+	line := start + 1
+	for _, member := range members {
+		id := typeName + "." + member
+		if strings.Contains(declHTML, `id="`+id+`"`) {
+			continue
+		}
+		for i := line; i < len(lines) && depths[i] >= depth; i++ {
+			if depths[i] != depth || !containsString(leadingNames(texts[i]), member) {
+				continue
+			}
+			lines[i] = `<span id="` + id + `"></span>` + lines[i]
+			line = i
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lineDepths returns the number of brackets open at the start of each line
+// of a declaration, ignoring the line comments.
+func lineDepths(lines []string) (depths []int) {
+	depth := 0
+	for _, line := range lines {
+		depths = append(depths, depth)
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		for _, c := range line {
+			switch c {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				depth--
+			}
+		}
+	}
+	return
+}
+
+// leadingNames returns the comma separated identifiers a declaration line
+// starts with, e.g. ["A", "B"] for "A, B int".
+func leadingNames(text string) (names []string) {
+	for {
+		i := 0
+		for i < len(text) && isIdentByte(text[i]) {
+			i++
+		}
+		if i == 0 {
+			return
+		}
+		names = append(names, text[:i])
+		text = text[i:]
+		if !strings.HasPrefix(text, ",") {
+			return
+		}
+		text = strings.TrimLeft(text[1:], " ")
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func valueIndexes(values []*doc.Value) (indexes []packageIndex) {
+	for _, value := range values {
+		for _, name := range value.Names {
+			if !ast.IsExported(name) {
+				continue
+			}
+			indexes = append(indexes, packageIndex{
+				Name: name,
+				Path: "#" + name,
+			})
+		}
+	}
+	return
+}
+
+func funcIndexes(funcs []*doc.Func) (indexes []packageIndex) {
+	for _, f := range funcs {
+		name := f.Name
+		if f.Recv != "" {
+			name = strings.TrimPrefix(f.Recv, "*") + "." + f.Name
+		}
+		indexes = append(indexes, packageIndex{
+			Name: name,
+			Path: "#" + name,
+		})
+	}
+	return
+}
+
+// AddDashAnchors inserts a Dash table of contents anchor before the element
+// each index points to.
+func (info *packageInfo) AddDashAnchors(doc *goquery.Document) {
+	groups := map[string][]packageIndex{
+		"Type":     info.Types,
+		"Function": info.Funcs,
+		"Field":    info.Fields,
+		"Method":   info.Methods,
+		"Constant": info.Consts,
+		"Variable": info.Variables,
+		"Sample":   info.Samples,
+		"Section":  info.Sections,
+	}
+	for typeName, indexes := range groups {
+		for _, index := range indexes {
+			if !strings.HasPrefix(index.Path, "#") {
+				continue
+			}
+			anchor := fmt.Sprintf(`<a name="//apple_ref/cpp/%s/%s" class="dashAnchor"></a>`,
+				url.PathEscape(index.entryType(typeName)),
+				url.PathEscape(index.displayName()),
+			)
+			doc.Find(`[id="` + index.Path[1:] + `"]`).First().BeforeHtml(anchor)
+		}
+	}
+}
+
+// WriteInsert inserts the indexes of the package, only keeping the entry
+// types listed in types if any.
+func (info *packageInfo) WriteInsert(stmt *sql.Stmt, types []string) (err error) {
+	if keepEntryType(types, "Package") {
+		_, err = stmt.Exec(info.Name, "Package", getDocumentPath(info.Name))
+		if err != nil {
+			return
+		}
+	}
+	err = info.writeIndexes(stmt, types, "Type", info.Types)
+	if err != nil {
+		return
+	}
+	err = info.writeIndexes(stmt, types, "Function", info.Funcs)
+	if err != nil {
+		return
+	}
+	err = info.writeIndexes(stmt, types, "Field", info.Fields)
+	if err != nil {
+		return
+	}
+	err = info.writeIndexes(stmt, types, "Method", info.Methods)
 	if err != nil {
 		return
 	}
-	err = info.writeIndexes(stmt, "Type", info.Types)
+	err = info.writeIndexes(stmt, types, "Constant", info.Consts)
 	if err != nil {
 		return
 	}
-	err = info.writeIndexes(stmt, "Function", info.Funcs)
+	err = info.writeIndexes(stmt, types, "Variable", info.Variables)
 	if err != nil {
 		return
 	}
-	err = info.writeIndexes(stmt, "Constant", info.Consts)
+	err = info.writeIndexes(stmt, types, "Sample", info.Samples)
 	if err != nil {
 		return
 	}
-	err = info.writeIndexes(stmt, "Variable", info.Variables)
+	err = info.writeIndexes(stmt, types, "Section", info.Sections)
 	if err != nil {
 		return
 	}
@@ -182,11 +672,15 @@ func (info *packageInfo) WriteInsert(stmt *sql.Stmt) (err error) {
 	return
 }
 
-func (info *packageInfo) writeIndexes(stmt *sql.Stmt, typeName string, indexes []packageIndex) (err error) {
+func (info *packageInfo) writeIndexes(stmt *sql.Stmt, types []string, typeName string, indexes []packageIndex) (err error) {
 	for _, index := range indexes {
-		name := info.Name + "." + index.Name
+		entryType := index.entryType(typeName)
+		name := info.entryName(entryType, index)
+		if !keepEntryType(types, entryType) {
+			continue
+		}
 		p := getDocumentPath(info.Name) + index.Path
-		_, err = stmt.Exec(name, typeName, p)
+		_, err = stmt.Exec(name, entryType, p)
 		if err != nil {
 			return
 		}
@@ -194,3 +688,30 @@ func (info *packageInfo) writeIndexes(stmt *sql.Stmt, typeName string, indexes [
 
 	return
 }
+
+// entryName returns the name of the index in the docset, qualified with the
+// package name. Methods are named "Type.Method" after their receiver.
+func (info *packageInfo) entryName(entryType string, index packageIndex) string {
+	if entryType == "Method" {
+		return index.Name
+	}
+	if entryType == "Section" {
+		return info.Name + ": " + index.Name
+	}
+	// package level entries like "(example)"
+	if strings.HasPrefix(index.Name, "(") {
+		return info.Name + " " + index.Name
+	}
+	return info.Name + "." + index.displayName()
+}
+
+// entryTypeNames lists the Dash entry types of the docset.
+var entryTypeNames = []string{
+	"Package", "Type", "Struct", "Interface", "Field",
+	"Function", "Method", "Constant", "Variable", "Sample", "Section",
+}
+
+// keepEntryType reports whether entries of a Dash entry type are indexed.
+func keepEntryType(types []string, entryType string) bool {
+	return len(types) == 0 || containsString(types, entryType)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitExampleName(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		suffix string
+	}{
+		{"", "", ""},
+		{"_second", "", "second"},
+		{"F", "F", ""},
+		{"F_second", "F", "second"},
+		{"F_second_third", "F", "second_third"},
+		{"T_Method", "T.Method", ""},
+		{"T_Method_second", "T.Method", "second"},
+		{"T_second", "T", "second"},
+		{"T__Method", "T", "_Method"},
+	}
+	for _, test := range tests {
+		target, suffix := splitExampleName(test.name)
+		if target != test.target || suffix != test.suffix {
+			t.Errorf("splitExampleName(%q) = %q, %q, want %q, %q", test.name, target, suffix, test.target, test.suffix)
+		}
+	}
+}
+
+func TestTypeKind(t *testing.T) {
+	tests := []struct {
+		decl  string
+		kind  string
+		alias bool
+	}{
+		{"type T struct{ X int }", "Struct", false},
+		{"type T interface{ M() }", "Interface", false},
+		{"type T int", "Type", false},
+		{"type T func()", "Type", false},
+		{"type T = U", "Type", true},
+		{"type T = struct{ X int }", "Type", true},
+	}
+	for _, test := range tests {
+		spec := parseTypeSpec("T", test.decl)
+		if kind, alias := typeKind(spec), isAlias(spec); kind != test.kind || alias != test.alias {
+			t.Errorf("%q: typeKind = %q, isAlias = %t, want %q, %t", test.decl, kind, alias, test.kind, test.alias)
+		}
+	}
+}
+
+func TestLeadingNames(t *testing.T) {
+	tests := []struct {
+		text  string
+		names []string
+	}{
+		{"X int", []string{"X"}},
+		{"X, Y int", []string{"X", "Y"}},
+		{"X,Y int", []string{"X", "Y"}},
+		{"Read(p []byte) (n int, err error)", []string{"Read"}},
+		{"io.Reader", []string{"io"}},
+		{"*Embedded", nil},
+		{"// X is a field", nil},
+		{"}", nil},
+	}
+	for _, test := range tests {
+		if names := leadingNames(test.text); !reflect.DeepEqual(names, test.names) {
+			t.Errorf("leadingNames(%q) = %q, want %q", test.text, names, test.names)
+		}
+	}
+}
+
+func TestAnchorMembers(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		typeName string
+		members  []string
+		want     string
+	}{
+		{
+			"multi-name fields",
+			"type T struct {\n    X, Y int\n    Z    string\n}",
+			"T",
+			[]string{"X", "Y", "Z"},
+			"type T struct {\n" +
+				`<span id="T.Y"></span><span id="T.X"></span>    X, Y int` + "\n" +
+				`<span id="T.Z"></span>    Z    string` + "\n}",
+		},
+		{
+			"nested anonymous struct with a repeated field name",
+			"type T struct {\n    A struct {\n        X int\n    }\n    X int\n}",
+			"T",
+			[]string{"A", "X"},
+			"type T struct {\n" +
+				`<span id="T.A"></span>    A struct {` + "\n" +
+				"        X int\n    }\n" +
+				`<span id="T.X"></span>    X int` + "\n}",
+		},
+		{
+			"field documented with its own name",
+			"type T struct {\n    // Y is set with X.\n    Y int\n    X int\n}",
+			"T",
+			[]string{"Y", "X"},
+			"type T struct {\n    // Y is set with X.\n" +
+				`<span id="T.Y"></span>    Y int` + "\n" +
+				`<span id="T.X"></span>    X int` + "\n}",
+		},
+		{
+			"already anchored godoc fields",
+			"type <a href=\"/src/p/p.go?s=1:2#L1\">T</a> struct {\n" +
+				`    <span id="T.X"></span>X <a href="/pkg/builtin/#int">int</a>` + "\n" +
+				`    Y <a href="/pkg/builtin/#int">int</a>` + "\n}",
+			"T",
+			[]string{"X", "Y"},
+			"type <a href=\"/src/p/p.go?s=1:2#L1\">T</a> struct {\n" +
+				`    <span id="T.X"></span>X <a href="/pkg/builtin/#int">int</a>` + "\n" +
+				`<span id="T.Y"></span>    Y <a href="/pkg/builtin/#int">int</a>` + "\n}",
+		},
+		{
+			"interface methods",
+			"type I interface {\n    io.Reader\n    Close() error\n}",
+			"I",
+			[]string{"Close"},
+			"type I interface {\n    io.Reader\n" +
+				`<span id="I.Close"></span>    Close() error` + "\n}",
+		},
+		{
+			"grouped type declaration",
+			"type (\n    U struct {\n        X int\n    }\n    T struct {\n        X int\n    }\n)",
+			"T",
+			[]string{"X"},
+			"type (\n    U struct {\n        X int\n    }\n    T struct {\n" +
+				`<span id="T.X"></span>        X int` + "\n    }\n)",
+		},
+	}
+	for _, test := range tests {
+		if got := anchorMembers(test.html, test.typeName, test.members); got != test.want {
+			t.Errorf("%s: anchorMembers =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// pkgsitePageTemplate wraps the documentation of a pkgsite page, without
+// the site navigation and scripts which don't work offline.
+var pkgsitePageTemplate = template.Must(template.New("pkgsite").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} - GoDoc</title>
+<style>
+body { font-family: Arial, sans-serif; font-size: 14px; line-height: 1.4; margin: 0 20px; color: #222; }
+pre, code { font-family: Menlo, monospace; font-size: 13px; }
+pre { background: #efefef; padding: 10px; border-radius: 5px; line-height: 1.3; overflow-x: auto; }
+h1 { font-size: 24px; }
+h3 { font-size: 20px; background: #e0ebf5; padding: 8px; margin: 20px 0 10px; }
+h4 { font-size: 18px; margin: 20px 0 10px; }
+a { color: #375eab; text-decoration: none; }
+.Documentation-idLink { display: none; }
+h3:hover .Documentation-idLink, h4:hover .Documentation-idLink { display: inline; }
+summary { font-weight: bold; cursor: pointer; }
+</style>
+</head>
+<body>
+<div id="page">
+<h1>Package {{.Name}}</h1>
+<div id="short-nav"><dl><dd><code>import "{{.Name}}"</code></dd></dl></div>
+{{.Content}}
+</div>
+</body>
+</html>
+`))
+
+type pkgsitePage struct {
+	Name    string
+	Content template.HTML
+}
+
+// generateFromPkgsite scrapes the pages of the packages from a pkgsite
+// -server. pkgsite doesn't list the packages it serves, so they are listed
+// like without -server.
+func generateFromPkgsite(ctx context.Context, g *docsetGroup, incremental bool) (err error) {
+	packages, err := g.source.listPackages()
+	if err != nil {
+		return
+	}
+	g.host = g.source.server
+
+	docsets := g.docsets
+	var selected []localPackage
+	for _, pkg := range packages {
+		if g.selectPackage(pkg) {
+			selected = append(selected, pkg)
+		}
+	}
+	if dryRun {
+		printSelected(docsets)
+		return
+	}
+
+	err = createDocsets(ctx, docsets, incremental, func() error {
+		p := newPool(ctx, jobs)
+		for _, pkg := range selected {
+			pkg := pkg
+			p.Go(func() {
+				g.grabPkgsitePackage(ctx, pkg)
+			})
+		}
+		p.Wait()
+		return nil
+	})
+	return
+}
+
+// grabPkgsitePackage downloads a package page once, and writes it into
+// every docset the package belongs to.
+func (g *docsetGroup) grabPkgsitePackage(ctx context.Context, pkg localPackage) {
+	cache := newPageCache(ctx, g.host, g.source.header)
+	g.writePackage(pkg, func(d *docset) *packageInfo {
+		return grabPkgsitePage(d, cache, pkg.ImportPath)
+	})
+}
+
+func grabPkgsitePage(d *docset, cache *pageCache, packageName string) (info *packageInfo) {
+	info = &packageInfo{Name: packageName}
+	var err error
+	defer func() {
+		info.Err = err
+	}()
+
+	buf, err := cache.fetch(packageName)
+	if err != nil {
+		return
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(buf))
+	if err != nil {
+		return
+	}
+
+	info.ParsePkgsite(doc)
+	if info.IsEmpty() {
+		return
+	}
+
+	content := doc.Find("div.Documentation-content").First()
+	if content.Length() == 0 {
+		content = doc.Find("body")
+	}
+	// the playground buttons need the pkgsite server
+	content.Find(".Documentation-exampleButtonsContainer").Remove()
+	content.Find("details.Documentation-exampleDetails").SetAttr("open", "")
+	contentHTML, err := goquery.OuterHtml(content)
+	if err != nil {
+		return
+	}
+
+	page := &bytes.Buffer{}
+	err = pkgsitePageTemplate.Execute(page, pkgsitePage{
+		Name:    packageName,
+		Content: template.HTML(contentHTML),
+	})
+	if err != nil {
+		return
+	}
+	doc, err = goquery.NewDocumentFromReader(page)
+	if err != nil {
+		return
+	}
+
+	info.ParseText(doc)
+	info.AddDashAnchors(doc)
+	documentPath := getDocumentPath(info.Name)
+	d.replacePkgsiteLinks(doc, documentPath)
+	newHTML, err := goquery.OuterHtml(doc.Selection)
+	if err != nil {
+		return
+	}
+
+	err = writeFile(d.dir, documentPath, strings.NewReader(newHTML))
+	if err != nil {
+		return
+	}
+
+	err = d.insert(info)
+	return
+}
+
+// pkgsiteRoot is the site the links of pkgsite pages which are not package
+// links point to, unless the fallback URL is a web site.
+const pkgsiteRoot = "https://pkg.go.dev"
+
+// replacePkgsiteLinks rewrites the package links of a pkgsite page, like
+// "/github.com/foo/bar@v1.2.3/baz#Thing", with any "@version" stripped, like
+// replacePackageLinks. The other links, like "/about", point to the site
+// root of the fallback URL, as the server is usually not reachable.
+func (d *docset) replacePkgsiteLinks(doc *goquery.Document, documentPath string) {
+	root := pkgsiteRoot
+	if u, err := url.Parse(d.config.Fallback); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		root = u.Scheme + "://" + u.Host
+	}
+
+	dir := path.Dir(documentPath)
+
+	doc.Find(`a[href^="/"]`).Each(func(index int, selection *goquery.Selection) {
+		href, _ := selection.Attr("href")
+		// protocol relative URLs
+		if strings.HasPrefix(href, "//") {
+			return
+		}
+		packagePath, fragment := href, ""
+		if i := strings.Index(packagePath, "#"); i >= 0 {
+			packagePath, fragment = packagePath[:i], packagePath[i:]
+		}
+		if i := strings.Index(packagePath, "?"); i >= 0 {
+			packagePath = packagePath[:i]
+		}
+		packageName := stripVersion(strings.Trim(packagePath, "/"))
+
+		if !d.packages[packageName] && !isPackagePath(packageName) {
+			selection.SetAttr("href", root+href)
+			return
+		}
+		newHref, err := d.packageHref(dir, packageName, fragment)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		selection.SetAttr("href", newHref)
+	})
+}
+
+// isPackagePath reports whether a pkgsite path is a package, of the
+// standard library or whose first element is a domain name, rather than a
+// page of the site.
+func isPackagePath(p string) bool {
+	if p == "" {
+		return false
+	}
+	if isStandardPackage(p) {
+		return true
+	}
+	return strings.Contains(strings.SplitN(p, "/", 2)[0], ".")
+}
+
+// stripVersion removes the "@version" of the module path element of a
+// pkgsite path, e.g. "github.com/foo/bar@v1.2.3/baz".
+func stripVersion(p string) string {
+	i := strings.Index(p, "@")
+	if i < 0 {
+		return p
+	}
+	j := strings.Index(p[i:], "/")
+	if j < 0 {
+		return p[:i]
+	}
+	return p[:i] + p[i+j:]
+}
+
+// ParsePkgsite indexes a pkgsite page, whose declarations have their
+// anchor as id and their kind in a data-kind attribute. Struct fields and
+// interface methods are anchored by pkgsite as well.
+func (info *packageInfo) ParsePkgsite(doc *goquery.Document) {
+	doc.Find("[data-kind]").Each(func(index int, selection *goquery.Selection) {
+		id, ok := selection.Attr("id")
+		if !ok || id == "" {
+			return
+		}
+		kind, _ := selection.Attr("data-kind")
+		entry := packageIndex{
+			Name: id,
+			Path: "#" + id,
+		}
+		switch kind {
+		case "constant":
+			info.Consts = append(info.Consts, entry)
+		case "variable":
+			info.Variables = append(info.Variables, entry)
+		case "function":
+			info.Funcs = append(info.Funcs, entry)
+		case "method":
+			info.Methods = append(info.Methods, entry)
+		case "field":
+			info.Fields = append(info.Fields, entry)
+		case "type":
+			decl := selection.Closest("div.Documentation-type").Find("div.Documentation-declaration pre").First()
+			spec := parseTypeSpec(id, decl.Text())
+			entry.Kind = typeKind(spec)
+			entry.Alias = isAlias(spec)
+			info.Types = append(info.Types, entry)
+		}
+	})
+
+	doc.Find("details.Documentation-exampleDetails").Each(func(index int, selection *goquery.Selection) {
+		id, ok := selection.Attr("id")
+		if !ok || !strings.HasPrefix(id, "example-") {
+			return
+		}
+		target, suffix := splitPkgsiteExampleID(id)
+		info.Samples = append(info.Samples, packageIndex{
+			Name: exampleLabel(target, suffix),
+			Path: "#" + id,
+		})
+	})
+
+	info.ParseSections(doc)
+}
+
+// splitPkgsiteExampleID splits a pkgsite example id, like "example-T.Method",
+// "example-package" or "example-Func-Second", into the identifier it belongs
+// to and its suffix, lower cased like in the example function name.
+func splitPkgsiteExampleID(id string) (target string, suffix string) {
+	name := strings.TrimPrefix(id, "example-")
+	target = name
+	if i := strings.Index(name, "-"); i >= 0 {
+		target, suffix = name[:i], name[i+1:]
+	}
+	if target == "package" {
+		target = ""
+	}
+	if r, size := utf8.DecodeRuneInString(suffix); size > 0 {
+		suffix = string(unicode.ToLower(r)) + suffix[size:]
+	}
+	return
+}
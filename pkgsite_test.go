@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestSplitPkgsiteExampleID(t *testing.T) {
+	tests := []struct {
+		id     string
+		target string
+		suffix string
+	}{
+		{"example-package", "", ""},
+		{"example-package-Second", "", "second"},
+		{"example-Func", "Func", ""},
+		{"example-Func-Second", "Func", "second"},
+		{"example-Func-MultiWord", "Func", "multiWord"},
+		{"example-T", "T", ""},
+		{"example-T.Method", "T.Method", ""},
+		{"example-T.Method-Second", "T.Method", "second"},
+	}
+	for _, test := range tests {
+		target, suffix := splitPkgsiteExampleID(test.id)
+		if target != test.target || suffix != test.suffix {
+			t.Errorf("splitPkgsiteExampleID(%q) = %q, %q, want %q, %q", test.id, target, suffix, test.target, test.suffix)
+		}
+	}
+}
+
+func TestStripVersion(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"github.com/foo/bar", "github.com/foo/bar"},
+		{"github.com/foo/bar@v1.2.3", "github.com/foo/bar"},
+		{"github.com/foo/bar@v1.2.3/baz", "github.com/foo/bar/baz"},
+		{"golang.org/x/net@v0.0.0-20181114220301-adae6a3d119a/html", "golang.org/x/net/html"},
+		{"fmt@go1.21.0", "fmt"},
+		{"about", "about"},
+	}
+	for _, test := range tests {
+		if got := stripVersion(test.path); got != test.want {
+			t.Errorf("stripVersion(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestParsePkgsite(t *testing.T) {
+	f, err := os.Open("testdata/pkgsite.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := &packageInfo{Name: "example.com/greet"}
+	info.ParsePkgsite(doc)
+	tests := []struct {
+		kind string
+		got  []packageIndex
+		want []packageIndex
+	}{
+		{"consts", info.Consts, []packageIndex{
+			{Name: "Formal", Path: "#Formal"},
+			{Name: "Casual", Path: "#Casual"},
+		}},
+		{"variables", info.Variables, []packageIndex{
+			{Name: "DefaultGreeter", Path: "#DefaultGreeter"},
+		}},
+		{"funcs", info.Funcs, []packageIndex{
+			{Name: "Hello", Path: "#Hello"},
+		}},
+		{"methods", info.Methods, []packageIndex{
+			{Name: "Greeter.Greet", Path: "#Greeter.Greet"},
+			{Name: "Namer.Name", Path: "#Namer.Name"},
+		}},
+		{"types", info.Types, []packageIndex{
+			{Name: "Greeter", Path: "#Greeter", Kind: "Struct"},
+			{Name: "Namer", Path: "#Namer", Kind: "Interface"},
+			{Name: "Salutation", Path: "#Salutation", Kind: "Type", Alias: true},
+			{Name: "Style", Path: "#Style", Kind: "Type"},
+		}},
+		{"fields", info.Fields, []packageIndex{
+			{Name: "Greeter.Style", Path: "#Greeter.Style"},
+			{Name: "Greeter.Names", Path: "#Greeter.Names"},
+		}},
+		{"samples", info.Samples, []packageIndex{
+			{Name: "(example)", Path: "#example-package"},
+			{Name: "Hello (example)", Path: "#example-Hello"},
+			{Name: "Greeter.Greet (example formal)", Path: "#example-Greeter.Greet-Formal"},
+		}},
+		{"sections", info.Sections, []packageIndex{
+			{Name: "Greeting styles", Path: "#hdr-Greeting_styles"},
+		}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%s = %+v, want %+v", test.kind, test.got, test.want)
+		}
+	}
+}
+
+func TestReplacePkgsiteLinks(t *testing.T) {
+	tests := []struct {
+		fallback string
+		href     string
+		want     string
+	}{
+		{"https://pkg.go.dev/", "/example.com/greet@v1.2.0/names#Name", "names/index.html#Name"},
+		{"https://pkg.go.dev/", "/example.com/greet/names?tab=doc", "names/index.html"},
+		{"https://pkg.go.dev/", "/example.com/other@v0.1.0/sub#T", "https://pkg.go.dev/example.com/other/sub#T"},
+		{"https://docs.example.com/pkg/", "/example.com/other#T", "https://docs.example.com/pkg/example.com/other#T"},
+		{"dash://go:", "/example.com/other@v0.1.0#T", "dash://go:example.com/other.T"},
+		{"dash://go:", "/example.com/greet@v1.2.0/names#Name", "names/index.html#Name"},
+		{"https://docs.example.com/pkg/", "/about", "https://docs.example.com/about"},
+		{"https://pkg.go.dev/", "/search?q=greet", "https://pkg.go.dev/search?q=greet"},
+		{"dash://go:", "/license-policy", "https://pkg.go.dev/license-policy"},
+		{"https://pkg.go.dev/", "//example.com/greet", "//example.com/greet"},
+	}
+	for _, test := range tests {
+		d := &docset{
+			config: docsetConfig{Fallback: test.fallback},
+			packages: map[string]bool{
+				"example.com/greet":       true,
+				"example.com/greet/names": true,
+			},
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<a href="` + test.href + `">link</a>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.replacePkgsiteLinks(doc, getDocumentPath("example.com/greet"))
+		if got, _ := doc.Find("a").Attr("href"); got != test.want {
+			t.Errorf("%s with fallback %s: href = %q, want %q", test.href, test.fallback, got, test.want)
+		}
+	}
+}
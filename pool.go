@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// pool runs tasks with a bounded number of concurrent workers. It is shared
+// by package pages, static resources and source files so that the number of
+// requests in flight never exceeds -jobs.
+type pool struct {
+	ctx   context.Context
+	wg    sync.WaitGroup
+	slots chan struct{}
+}
+
+func newPool(ctx context.Context, jobs int) *pool {
+	if jobs < 1 {
+		jobs = 1
+	}
+	return &pool{
+		ctx:   ctx,
+		slots: make(chan struct{}, jobs),
+	}
+}
+
+// Go runs task once a worker is free, unless the context of the pool is
+// canceled by then. A task may submit other tasks, but must not wait for
+// them.
+func (p *pool) Go(task func()) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.slots <- struct{}{}
+		defer func() {
+			<-p.slots
+		}()
+		if p.ctx.Err() != nil {
+			return
+		}
+		task()
+	}()
+}
+
+// Wait waits for every submitted task, including the ones submitted by
+// other tasks.
+func (p *pool) Wait() {
+	p.wg.Wait()
+}
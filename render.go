@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type localPackage struct {
+	ImportPath string
+	Dir        string
+	// Version is the module version of a package from the module cache.
+	Version string
+}
+
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"comment": commentHTML,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} - GoDoc</title>
+<style>
+body { font-family: Arial, sans-serif; font-size: 14px; line-height: 1.4; margin: 0 20px; color: #222; }
+pre, code { font-family: Menlo, monospace; font-size: 13px; }
+pre { background: #efefef; padding: 10px; border-radius: 5px; line-height: 1.3; overflow-x: auto; }
+h1 { font-size: 24px; }
+h2 { font-size: 20px; background: #e0ebf5; padding: 8px; margin: 20px 0 10px; }
+h3 { font-size: 18px; margin: 20px 0 10px; }
+a { color: #375eab; text-decoration: none; }
+a.permalink { display: none; }
+h2:hover a.permalink, h3:hover a.permalink { display: inline; }
+.exampleHeading { font-weight: bold; }
+pre.output { background: #fff; border: 1px solid #efefef; }
+</style>
+</head>
+<body>
+<div id="page">
+<h1>Package {{.Name}}</h1>
+<div id="short-nav"><dl><dd><code>import "{{.ImportPath}}"</code></dd></dl></div>
+<h2 id="pkg-overview">Overview <a class="permalink" href="#pkg-overview">&#xb6;</a></h2>
+{{comment .Doc}}
+{{template "examples" .Examples}}
+{{with .Consts}}<h2 id="pkg-constants">Constants</h2>
+{{range .}}<pre>{{.Decl}}</pre>
+{{comment .Doc}}{{end}}{{end}}
+{{with .Vars}}<h2 id="pkg-variables">Variables</h2>
+{{range .}}<pre>{{.Decl}}</pre>
+{{comment .Doc}}{{end}}{{end}}
+{{range .Funcs}}<h2 id="{{.Name}}">func <a href="{{.Src}}">{{.Name}}</a> <a class="permalink" href="#{{.Name}}">&#xb6;</a></h2>
+<pre>{{.Decl}}</pre>
+{{comment .Doc}}{{template "examples" .Examples}}{{end}}
+{{range .Types}}<h2 id="{{.Name}}">type <a href="{{.Src}}">{{.Name}}</a> <a class="permalink" href="#{{.Name}}">&#xb6;</a></h2>
+{{comment .Doc}}<pre>{{.Decl}}</pre>
+{{template "examples" .Examples}}{{range .Consts}}<pre>{{.Decl}}</pre>
+{{comment .Doc}}{{end}}{{range .Vars}}<pre>{{.Decl}}</pre>
+{{comment .Doc}}{{end}}{{range .Funcs}}<h3 id="{{.Name}}">func <a href="{{.Src}}">{{.Name}}</a> <a class="permalink" href="#{{.Name}}">&#xb6;</a></h3>
+<pre>{{.Decl}}</pre>
+{{comment .Doc}}{{template "examples" .Examples}}{{end}}{{range .Methods}}<h3 id="{{.ID}}">func ({{.Recv}}) <a href="{{.Src}}">{{.Name}}</a> <a class="permalink" href="#{{.ID}}">&#xb6;</a></h3>
+<pre>{{.Decl}}</pre>
+{{comment .Doc}}{{template "examples" .Examples}}{{end}}{{end}}
+</div>
+</body>
+</html>
+{{define "examples"}}{{range .}}<div id="example_{{.Name}}" class="toggleVisible">
+<div class="expanded">
+<p class="exampleHeading">{{.Title}}</p>
+{{comment .Doc}}<p>Code:</p>
+<pre class="code">{{.Code}}</pre>
+{{with .Output}}<p>Output:</p>
+<pre class="output">{{.}}</pre>
+{{end}}</div>
+</div>
+{{end}}{{end}}`))
+
+type pageData struct {
+	Name       string
+	ImportPath string
+	Doc        string
+	Examples   []pageExample
+	Consts     []pageValue
+	Vars       []pageValue
+	Funcs      []pageFunc
+	Types      []pageType
+}
+
+type pageValue struct {
+	Doc  string
+	Decl template.HTML
+}
+
+type pageFunc struct {
+	ID       string
+	Name     string
+	Recv     string
+	Src      string
+	Doc      string
+	Decl     template.HTML
+	Examples []pageExample
+}
+
+type pageType struct {
+	Name     string
+	Src      string
+	Doc      string
+	Decl     template.HTML
+	Examples []pageExample
+	Consts   []pageValue
+	Vars     []pageValue
+	Funcs    []pageFunc
+	Methods  []pageFunc
+}
+
+type pageExample struct {
+	Name   string
+	Title  string
+	Doc    string
+	Code   string
+	Output string
+}
+
+// listLocalPackages walks every $GOPATH/src directory and returns the
+// directories containing buildable Go packages.
+func (s *docsetSource) listLocalPackages() (packages []localPackage, err error) {
+	for _, root := range filepath.SplitList(s.build.GOPATH) {
+		src := filepath.Join(root, "src")
+		if _, statErr := os.Stat(src); statErr != nil {
+			continue
+		}
+		err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if !fi.IsDir() {
+				return nil
+			}
+			if p != src && isIgnoredDir(fi.Name()) {
+				return filepath.SkipDir
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil || rel == "." {
+				return nil
+			}
+			importPath := filepath.ToSlash(rel)
+
+			if !s.keepPackage(importPath) {
+				return nil
+			}
+
+			if _, err := s.build.ImportDir(p, 0); err != nil {
+				return nil
+			}
+			packages = append(packages, localPackage{
+				ImportPath: importPath,
+				Dir:        p,
+			})
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// isIgnoredDir reports whether the go command ignores the packages of a
+// directory, like "testdata" or hidden ones.
+func isIgnoredDir(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") ||
+		name == "testdata" || name == "vendor"
+}
+
+func renderPackages(p *pool, docsets []*docset, packages []localPackage) {
+	for _, pkg := range packages {
+		pkg := pkg
+		p.Go(func() {
+			renderPackage(docsets, pkg)
+		})
+	}
+	return
+}
+
+// renderPackage renders the page and source files of a package once, and
+// writes them into every docset the package belongs to.
+func renderPackage(docsets []*docset, pkg localPackage) {
+	var page *packagePage
+	for _, d := range docsets {
+		if !d.packages[pkg.ImportPath] {
+			continue
+		}
+		d.manifest.Update(pkg, func() (err error) {
+			if page == nil {
+				page = renderPackagePage(pkg)
+				page.info.Print()
+			}
+			// skip packages without any exported declaration
+			if page.info.Err != nil || page.info.IsEmpty() {
+				return page.info.Err
+			}
+			err = page.write(d)
+			if err != nil {
+				fmt.Printf("\n%s error: %s\n\n"+splitter, pkg.ImportPath, err.Error())
+			}
+			return
+		})
+	}
+}
+
+// packagePage is the rendered page of a package, along with the pages of
+// its source files.
+type packagePage struct {
+	info    *packageInfo
+	html    string
+	sources map[string][]byte
+}
+
+func renderPackagePage(pkg localPackage) (page *packagePage) {
+	page = &packagePage{
+		info:    &packageInfo{Name: pkg.ImportPath},
+		sources: map[string][]byte{},
+	}
+	var err error
+	defer func() {
+		page.info.Err = err
+	}()
+
+	fset, docPkg, examples, err := loadPackage(pkg)
+	if err != nil {
+		return
+	}
+
+	page.info.ParseDoc(docPkg, examples)
+	if page.info.IsEmpty() {
+		return
+	}
+
+	html, err := renderPage(fset, docPkg, examples)
+	if err != nil {
+		return
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return
+	}
+	page.info.ParseSections(doc)
+	page.info.valueCommentsFollow = true
+	page.info.ParseText(doc)
+	page.info.AddDashAnchors(doc)
+	documentPath := getDocumentPath(page.info.Name)
+	sources := replaceSourceLinks(doc, documentPath, page.info.Name)
+	page.html, err = goquery.OuterHtml(doc.Selection)
+	if err != nil {
+		return
+	}
+
+	for _, src := range sources {
+		page.sources[src], err = renderSource(pkg.Dir, page.info.Name, src)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// write writes the pages into the docset, and inserts the indexes of the
+// package.
+func (page *packagePage) write(d *docset) (err error) {
+	err = writeFile(d.dir, getDocumentPath(page.info.Name), strings.NewReader(page.html))
+	if err != nil {
+		return
+	}
+	for src, buf := range page.sources {
+		err = writeFile(d.dir, getSourcePath(src), bytes.NewReader(buf))
+		if err != nil {
+			return
+		}
+	}
+	err = d.insert(page.info)
+	return
+}
+
+// loadPackage parses the non-test Go files of a package that match the
+// current build context, and computes its documentation. The examples are
+// extracted from its test files, skipping the ones which don't parse as the
+// examples are optional.
+func loadPackage(pkg localPackage) (fset *token.FileSet, docPkg *doc.Package, examples []*doc.Example, err error) {
+	bp, err := build.Default.ImportDir(pkg.Dir, 0)
+	if err != nil {
+		return
+	}
+	files := map[string]bool{}
+	for _, name := range append(bp.GoFiles, bp.CgoFiles...) {
+		files[name] = true
+	}
+
+	fset = token.NewFileSet()
+	astPkgs, err := parser.ParseDir(fset, pkg.Dir, func(fi os.FileInfo) bool {
+		return files[fi.Name()]
+	}, parser.ParseComments)
+	if err != nil {
+		return
+	}
+	astPkg, ok := astPkgs[bp.Name]
+	if !ok {
+		err = fmt.Errorf("package %s not found in %s", bp.Name, pkg.Dir)
+		return
+	}
+
+	docPkg = doc.New(astPkg, pkg.ImportPath, 0)
+
+	var testFiles []*ast.File
+	for _, name := range append(bp.TestGoFiles, bp.XTestGoFiles...) {
+		f, parseErr := parser.ParseFile(fset, filepath.Join(pkg.Dir, name), nil, parser.ParseComments)
+		if parseErr != nil {
+			fmt.Printf("%s: skip the examples of %s: %s\n", pkg.ImportPath, name, parseErr.Error())
+			continue
+		}
+		testFiles = append(testFiles, f)
+	}
+	examples = doc.Examples(testFiles...)
+	return
+}
+
+func renderPage(fset *token.FileSet, p *doc.Package, examples []*doc.Example) (page string, err error) {
+	// group the examples by the identifier they belong to
+	targetExamples := map[string][]pageExample{}
+	for _, example := range examples {
+		target, suffix := splitExampleName(example.Name)
+		title := "Example"
+		if suffix != "" {
+			title += " (" + strings.Title(suffix) + ")"
+		}
+		targetExamples[target] = append(targetExamples[target], pageExample{
+			Name:   example.Name,
+			Title:  title,
+			Doc:    example.Doc,
+			Code:   exampleCode(fset, example),
+			Output: example.Output,
+		})
+	}
+
+	data := pageData{
+		Name:       p.Name,
+		ImportPath: p.ImportPath,
+		Doc:        p.Doc,
+		Examples:   targetExamples[""],
+		Consts:     newPageValues(fset, p.Consts),
+		Vars:       newPageValues(fset, p.Vars),
+		Funcs:      newPageFuncs(fset, p.ImportPath, p.Funcs, targetExamples),
+	}
+	for _, t := range p.Types {
+		var pos token.Pos
+		if spec := findTypeSpec(t.Decl, t.Name); spec != nil {
+			pos = spec.Pos()
+		}
+		data.Types = append(data.Types, pageType{
+			Name:     t.Name,
+			Src:      sourceLink(fset, p.ImportPath, pos),
+			Doc:      t.Doc,
+			Decl:     declHTML(fset, t.Decl),
+			Examples: targetExamples[t.Name],
+			Consts:   newPageValues(fset, t.Consts),
+			Vars:     newPageValues(fset, t.Vars),
+			Funcs:    newPageFuncs(fset, p.ImportPath, t.Funcs, targetExamples),
+			Methods:  newPageFuncs(fset, p.ImportPath, t.Methods, targetExamples),
+		})
+	}
+
+	buf := &bytes.Buffer{}
+	err = pageTemplate.Execute(buf, data)
+	if err != nil {
+		return
+	}
+	page = buf.String()
+	return
+}
+
+func newPageValues(fset *token.FileSet, values []*doc.Value) (result []pageValue) {
+	for _, v := range values {
+		result = append(result, pageValue{
+			Doc:  v.Doc,
+			Decl: declHTML(fset, v.Decl),
+		})
+	}
+	return
+}
+
+func newPageFuncs(fset *token.FileSet, importPath string, funcs []*doc.Func, targetExamples map[string][]pageExample) (result []pageFunc) {
+	for _, f := range funcs {
+		id := f.Name
+		recv := strings.TrimPrefix(f.Recv, "*")
+		if recv != "" {
+			id = recv + "." + f.Name
+		}
+		result = append(result, pageFunc{
+			ID:       id,
+			Name:     f.Name,
+			Recv:     f.Recv,
+			Src:      sourceLink(fset, importPath, f.Decl.Pos()),
+			Doc:      f.Doc,
+			Decl:     declHTML(fset, f.Decl),
+			Examples: targetExamples[id],
+		})
+	}
+	return
+}
+
+// sourceLink returns the godoc link to the source line of a declaration,
+// like "/src/<package>/file.go#L5".
+func sourceLink(fset *token.FileSet, importPath string, pos token.Pos) string {
+	if !pos.IsValid() {
+		return ""
+	}
+	position := fset.Position(pos)
+	return fmt.Sprintf("/src/%s/%s#L%d", importPath, filepath.Base(position.Filename), position.Line)
+}
+
+// declHTML prints a declaration as escaped HTML. Exported constant and
+// variable names are wrapped in <span id="Name">, and struct fields and
+// interface methods are anchored like godoc does, so they can be linked to.
+func declHTML(fset *token.FileSet, decl ast.Decl) template.HTML {
+	buf := &bytes.Buffer{}
+	err := (&printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}).Fprint(buf, fset, decl)
+	if err != nil {
+		return template.HTML(template.HTMLEscapeString(err.Error()))
+	}
+	text := template.HTMLEscapeString(buf.String())
+
+	genDecl, ok := decl.(*ast.GenDecl)
+	if !ok {
+		return template.HTML(text)
+	}
+	if genDecl.Tok == token.TYPE {
+		for _, spec := range genDecl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			fields, methods := memberNames(typeSpec)
+			text = anchorMembers(text, typeSpec.Name.Name, append(fields, methods...))
+		}
+		return template.HTML(text)
+	}
+	if genDecl.Tok != token.CONST && genDecl.Tok != token.VAR {
+		return template.HTML(text)
+	}
+
+	// the names are declared at the start of the lines directly within the
+	// parentheses of the declaration, not in comments or values
+	lines := strings.Split(text, "\n")
+	depths := lineDepths(lines)
+	depth := 0
+	if genDecl.Lparen.IsValid() {
+		depth = 1
+	}
+	keyword := genDecl.Tok.String() + " "
+	line := 0
+	for _, spec := range genDecl.Specs {
+		valueSpec, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+		for _, ident := range valueSpec.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			for i := line; i < len(lines); i++ {
+				text := strings.TrimSpace(htmlTag.ReplaceAllString(lines[i], ""))
+				names := leadingNames(strings.TrimPrefix(text, keyword))
+				if depths[i] != depth || !containsString(names, ident.Name) {
+					continue
+				}
+				j := indexIdent(lines[i], ident.Name)
+				lines[i] = lines[i][:j] + `<span id="` + ident.Name + `">` + ident.Name + `</span>` + lines[i][j+len(ident.Name):]
+				line = i
+				break
+			}
+		}
+	}
+	return template.HTML(strings.Join(lines, "\n"))
+}
+
+// indexIdent returns the index of the first occurrence of name in s that is
+// a whole identifier, or -1.
+func indexIdent(s string, name string) int {
+	for from := 0; from < len(s); {
+		i := strings.Index(s[from:], name)
+		if i < 0 {
+			return -1
+		}
+		i += from
+		end := i + len(name)
+		if (i == 0 || !isIdentByte(s[i-1])) && (end == len(s) || !isIdentByte(s[end])) {
+			return i
+		}
+		from = end
+	}
+	return -1
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// exampleCode prints the body of an example function without its braces and
+// output comment, or the whole file for whole file examples.
+func exampleCode(fset *token.FileSet, example *doc.Example) string {
+	buf := &bytes.Buffer{}
+	node := &printer.CommentedNode{Node: example.Code, Comments: example.Comments}
+	err := (&printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}).Fprint(buf, fset, node)
+	if err != nil {
+		return err.Error()
+	}
+	code := buf.String()
+	if _, ok := example.Code.(*ast.BlockStmt); !ok {
+		return code
+	}
+
+	code = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(code), "{"), "}")
+	var lines []string
+	for _, line := range strings.Split(strings.Trim(code, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "// Output:") || strings.HasPrefix(trimmed, "// Unordered output:") {
+			break
+		}
+		lines = append(lines, strings.TrimPrefix(line, "\t"))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func commentHTML(text string) template.HTML {
+	buf := &bytes.Buffer{}
+	doc.ToHTML(buf, text, nil)
+	return template.HTML(buf.String())
+}
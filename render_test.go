@@ -0,0 +1,82 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestIndexIdent(t *testing.T) {
+	tests := []struct {
+		s    string
+		name string
+		want int
+	}{
+		{"A = 1", "A", 0},
+		{"AB, A = 1, 2", "A", 4},
+		{"xA, A_, A", "A", 8},
+		{"B = A", "A", 4},
+		{"B = 1", "A", -1},
+		{"", "A", -1},
+	}
+	for _, test := range tests {
+		if got := indexIdent(test.s, test.name); got != test.want {
+			t.Errorf("indexIdent(%q, %q) = %d, want %d", test.s, test.name, got, test.want)
+		}
+	}
+}
+
+func TestDeclHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			"single value",
+			"const A = 1",
+			`const <span id="A">A</span> = 1`,
+		},
+		{
+			"multiple names",
+			"var A, b, C = 1, 2, 3",
+			`var <span id="A">A</span>, b, <span id="C">C</span> = 1, 2, 3`,
+		},
+		{
+			"names in a preceding comment",
+			"const (\n\t// A comes before B.\n\tA = iota\n\t// B comes after A.\n\tB\n)",
+			"const (\n" +
+				"\t// A comes before B.\n" +
+				"\t<span id=\"A\">A</span> = iota\n" +
+				"\t// B comes after A.\n" +
+				"\t<span id=\"B\">B</span>\n)",
+		},
+		{
+			"names in a preceding value",
+			"var (\n\tA = map[string]int{\n\t\t\"B\": 1,\n\t}\n\tB = A\n)",
+			"var (\n" +
+				"\t<span id=\"A\">A</span> = map[string]int{\n" +
+				"\t\t&#34;B&#34;: 1,\n\t}\n" +
+				"\t<span id=\"B\">B</span> = A\n)",
+		},
+		{
+			"struct fields",
+			"type T struct {\n\tX, Y int\n\tZ struct {\n\t\tX int\n\t}\n}",
+			"type T struct {\n" +
+				"<span id=\"T.Y\"></span><span id=\"T.X\"></span>\tX, Y int\n" +
+				"<span id=\"T.Z\"></span>\tZ    struct {\n" +
+				"\t\tX int\n\t}\n}",
+		},
+	}
+	for _, test := range tests {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "", "package p\n"+test.src, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if got := string(declHTML(fset, f.Decls[0].(*ast.GenDecl))); got != test.want {
+			t.Errorf("%s: declHTML =\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
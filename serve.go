@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const searchLimit = 100
+
+var searchTemplate = template.Must(template.New("search").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{if .Query}}{{.Query}} - {{end}}{{.Name}}</title>
+<style>
+body { font-family: Arial, sans-serif; font-size: 14px; line-height: 1.4; margin: 0 20px; color: #222; }
+h1 { font-size: 24px; }
+a { color: #375eab; text-decoration: none; }
+input { font-size: 14px; padding: 4px; width: 400px; }
+table { border-collapse: collapse; margin: 10px 0; }
+td { padding: 2px 20px 2px 0; vertical-align: top; }
+h2 { font-size: 20px; }
+.type { color: #999; }
+.snippet { color: #555; padding-bottom: 8px; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<form action="/" method="get">
+<input type="search" name="q" value="{{.Query}}" placeholder="Search" autofocus>
+</form>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<table>
+{{range .Results}}<tr><td><a href="/docs/{{.Path}}">{{.Name}}</a></td><td class="type">{{.Type}}</td></tr>
+{{end}}</table>
+{{with .TextResults}}<h2>Documentation</h2>
+<table>
+{{range .}}<tr><td><a href="/docs/{{.Path}}">{{.Name}}</a></td><td class="type">{{.Type}}</td></tr>
+<tr><td colspan="2" class="snippet">{{.Snippet}}</td></tr>
+{{end}}</table>
+{{end}}{{if and .Query (not .Results) (not .TextResults) (not .Error)}}<p>No results.</p>{{end}}
+</body>
+</html>
+`))
+
+type searchPage struct {
+	Name        string
+	Query       string
+	Error       string
+	Results     []searchResult
+	TextResults []textResult
+}
+
+type searchResult struct {
+	Name string
+	Type string
+	Path string
+}
+
+// serve implements the serve subcommand, serving the documents of a docset
+// and a search page backed by its index.
+func serve(args []string) (err error) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: godocdash serve [flags] [<name>.docset]\n")
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "localhost:8080", "Address to listen on")
+	flags.BoolVar(&silent, "silent", false, "Silent mode (only print error)")
+	flags.Parse(args)
+
+	docsetPath := "GoDoc.docset"
+	if flags.NArg() > 0 {
+		docsetPath = flags.Arg(0)
+	}
+	db, err := openDocsetIndex(docsetPath)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	module, err := textIndexModule(db)
+	if err != nil {
+		return
+	}
+
+	name := strings.TrimSuffix(filepath.Base(docsetPath), ".docset")
+	mux := http.NewServeMux()
+	mux.Handle("/docs/", http.StripPrefix("/docs/", http.FileServer(http.Dir(filepath.Join(getResourcesDir(docsetPath), "Documents")))))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		page := searchPage{Name: name, Query: strings.TrimSpace(r.FormValue("q"))}
+		results, err := searchDocset(db, page.Query)
+		if err == nil && module != "" {
+			page.TextResults, err = searchText(db, module, page.Query, searchLimit)
+		}
+		if err != nil {
+			page.Error = err.Error()
+		}
+		page.Results = results
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		searchTemplate.Execute(w, page)
+	})
+
+	printf("serving %s on http://%s/\n", docsetPath, *addr)
+	err = http.ListenAndServe(*addr, mux)
+	return
+}
+
+// openDocsetIndex opens the index of a docset read-only.
+func openDocsetIndex(docsetPath string) (db *sql.DB, err error) {
+	indexPath := filepath.Join(getResourcesDir(docsetPath), "docSet.dsidx")
+	if _, err = os.Stat(indexPath); err != nil {
+		return
+	}
+	db, err = sql.Open("sqlite3", "file:"+indexPath+"?mode=ro")
+	return
+}
+
+// searchDocset returns the index entries whose name contains query, exact
+// and prefix matches first, or the packages of the docset without a query.
+func searchDocset(db *sql.DB, query string) (results []searchResult, err error) {
+	var rows *sql.Rows
+	if query == "" {
+		rows, err = db.Query("SELECT name, type, path FROM searchIndex WHERE type = 'Package' ORDER BY name")
+	} else {
+		like := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query)
+		rows, err = db.Query(`SELECT name, type, path FROM searchIndex
+			WHERE name LIKE ? ESCAPE '\'
+			ORDER BY name = ? COLLATE NOCASE DESC, name LIKE ? ESCAPE '\' DESC, length(name), name
+			LIMIT ?`, "%"+like+"%", query, like+"%", searchLimit)
+	}
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result searchResult
+		err = rows.Scan(&result.Name, &result.Type, &result.Path)
+		if err != nil {
+			return
+		}
+		results = append(results, result)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"html/template"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var sourceTemplate = template.Must(template.New("source").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Path}} - GoDoc</title>
+<style>
+body { font-family: Arial, sans-serif; font-size: 14px; margin: 0 20px; color: #222; }
+h1 { font-size: 20px; }
+a { color: #375eab; text-decoration: none; }
+pre { font-family: Menlo, monospace; font-size: 13px; line-height: 1.3; }
+.ln { color: #999; user-select: none; }
+.comment { color: #006600; }
+</style>
+</head>
+<body>
+<h1>Source file <a href="{{.PackageHref}}">{{.Package}}</a>/{{.File}}</h1>
+<pre>{{.Code}}</pre>
+</body>
+</html>
+`))
+
+type sourceData struct {
+	Path        string
+	Package     string
+	PackageHref string
+	File        string
+	Code        template.HTML
+}
+
+// replaceSourceLinks rewrites the links to the source files of a package,
+// like "/src/<package>/file.go?s=10:20#L5", to their local copies, and
+// returns the paths of the linked source files, e.g.
+// "src/<package>/file.go".
+func replaceSourceLinks(doc *goquery.Document, documentPath string, packageName string) (sources []string) {
+	dir := path.Dir(documentPath)
+	linked := map[string]bool{}
+
+	doc.Find(`a[href^="/src/"]`).Each(func(index int, selection *goquery.Selection) {
+		href, _ := selection.Attr("href")
+		fragment := ""
+		if i := strings.Index(href, "#"); i >= 0 {
+			href, fragment = href[:i], href[i:]
+		}
+		if i := strings.Index(href, "?"); i >= 0 {
+			href = href[:i]
+		}
+		src := strings.TrimPrefix(href, "/")
+		if path.Ext(src) != ".go" || path.Dir(src) != path.Join("src", packageName) {
+			return
+		}
+
+		newHref, err := filepath.Rel(dir, getSourcePath(src))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		selection.SetAttr("href", filepath.ToSlash(newHref)+fragment)
+
+		if !linked[src] {
+			linked[src] = true
+			sources = append(sources, src)
+		}
+	})
+	return
+}
+
+// grabSource downloads a source file page from godoc into the docset.
+func grabSource(d *docset, cache *pageCache, src string) (err error) {
+	buf, err := cache.fetch(src)
+	if err != nil {
+		return
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(buf))
+	if err != nil {
+		return
+	}
+
+	sourcePath := getSourcePath(src)
+	replaceLinks(doc, sourcePath)
+	newHTML, err := goquery.OuterHtml(doc.Selection)
+	if err != nil {
+		return
+	}
+
+	err = writeFile(d.dir, sourcePath, strings.NewReader(newHTML))
+	return
+}
+
+// renderSource renders the page of a source file of a package found in dir,
+// with line anchors and highlighted comments like godoc does.
+func renderSource(dir string, packageName string, src string) (page []byte, err error) {
+	file := path.Base(src)
+	code, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return
+	}
+
+	sourcePath := getSourcePath(src)
+	packageHref, err := filepath.Rel(path.Dir(sourcePath), getDocumentPath(packageName))
+	if err != nil {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	err = sourceTemplate.Execute(buf, sourceData{
+		Path:        src,
+		Package:     packageName,
+		PackageHref: filepath.ToSlash(packageHref),
+		File:        file,
+		Code:        highlightSource(code),
+	})
+	if err != nil {
+		return
+	}
+
+	page = buf.Bytes()
+	return
+}
+
+// highlightSource returns Go source as escaped HTML, with every line
+// prefixed by a <span id="L<line>"> anchor, and comments wrapped in
+// <span class="comment">.
+func highlightSource(code []byte) template.HTML {
+	code = bytes.Replace(code, []byte("\r\n"), []byte("\n"), -1)
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(code))
+	var s scanner.Scanner
+	s.Init(file, code, nil, scanner.ScanComments)
+
+	buf := &strings.Builder{}
+	last := 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.COMMENT {
+			continue
+		}
+		start := file.Offset(pos)
+		end := start + len(lit)
+		buf.WriteString(template.HTMLEscapeString(string(code[last:start])))
+		// keep the spans on a single line so lines can be anchored
+		for i, line := range strings.Split(string(code[start:end]), "\n") {
+			if i > 0 {
+				buf.WriteString("\n")
+			}
+			buf.WriteString(`<span class="comment">` + template.HTMLEscapeString(line) + `</span>`)
+		}
+		last = end
+	}
+	buf.WriteString(template.HTMLEscapeString(string(code[last:])))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for i := range lines {
+		lines[i] = fmt.Sprintf(`<span id="L%d" class="ln">%6d&nbsp;&nbsp;</span>`, i+1, i+1) + lines[i]
+	}
+	return template.HTML(strings.Join(lines, "\n"))
+}
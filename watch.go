@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// updateDocsets regenerates the packages of the changed directories with
+// -watch. The docsets generated successfully are updated in place, and the
+// other ones are generated again.
+func updateDocsets(ctx context.Context, groups []*docsetGroup, changed []string) (err error) {
+	for _, g := range groups {
+		var groupErr error
+		if g.generated {
+			groupErr = g.update(ctx, changed)
+		} else {
+			groupErr = g.generate(ctx, true)
+		}
+		if groupErr == context.Canceled {
+			return groupErr
+		}
+		if groupErr != nil && err == nil {
+			err = groupErr
+		}
+	}
+	return
+}
+
+// update regenerates the packages of the group in the changed directories,
+// adds the new packages and removes the deleted ones, without scanning
+// every package again.
+func (g *docsetGroup) update(ctx context.Context, changed []string) (err error) {
+	var updated []localPackage
+	var removed []localPackage
+	for _, dir := range changed {
+		pkg, ok := g.packages[dir]
+		if ok && packageRemoved(dir) {
+			removed = append(removed, pkg)
+			continue
+		}
+		if !ok {
+			pkg, ok = g.source.findPackage(dir)
+			if !ok || !g.selectPackage(pkg) {
+				continue
+			}
+		}
+		updated = append(updated, pkg)
+	}
+	if len(updated) == 0 && len(removed) == 0 {
+		return
+	}
+
+	err = updateInPlace(ctx, g.docsets, func() {
+		for _, pkg := range removed {
+			delete(g.packages, pkg.Dir)
+			for _, d := range g.docsets {
+				if d.packages[pkg.ImportPath] {
+					d.manifest.Delete(pkg.ImportPath)
+					d.drop(pkg.ImportPath)
+				}
+			}
+		}
+
+		p := newPool(ctx, jobs)
+		for _, pkg := range updated {
+			pkg := pkg
+			p.Go(func() {
+				g.regenerate(ctx, pkg)
+			})
+		}
+		p.Wait()
+	})
+	return
+}
+
+// regenerate generates a package into the docsets of the group it belongs
+// to, the same way the whole group is generated.
+func (g *docsetGroup) regenerate(ctx context.Context, pkg localPackage) {
+	switch {
+	case g.source.pkgsite:
+		g.grabPkgsitePackage(ctx, pkg)
+	case g.source.godoc:
+		g.grabPackage(ctx, pkg.ImportPath+"/")
+	default:
+		renderPackage(g.docsets, pkg)
+	}
+}
+
+// packageRemoved reports whether the directory of a package was removed,
+// or has no Go files anymore.
+func packageRemoved(dir string) bool {
+	_, err := build.Default.ImportDir(dir, 0)
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(*build.NoGoError); ok {
+		return true
+	}
+	_, statErr := os.Stat(dir)
+	return os.IsNotExist(statErr)
+}
+
+// findPackage returns the package in a directory which was not part of the
+// docsets, according to the source settings.
+func (s *docsetSource) findPackage(dir string) (pkg localPackage, ok bool) {
+	if _, err := s.build.ImportDir(dir, 0); err != nil {
+		return
+	}
+
+	if s.module != "" {
+		root, err := filepath.Abs(s.module)
+		if err != nil || !withinDir(root, dir) {
+			return
+		}
+		out, err := runGo(s.env, dir, "list", "-e", "-json", ".")
+		if err != nil {
+			return
+		}
+		var p goPackage
+		err = json.NewDecoder(bytes.NewReader(out)).Decode(&p)
+		if err != nil || p.Standard || p.Name == "" {
+			return
+		}
+		pkg = localPackage{ImportPath: p.ImportPath, Dir: dir}
+		ok = s.std != "only"
+		return
+	}
+
+	for _, root := range filepath.SplitList(s.build.GOPATH) {
+		src := filepath.Join(root, "src")
+		rel, err := filepath.Rel(src, dir)
+		if err != nil || rel == "." || !withinDir(src, dir) {
+			continue
+		}
+		importPath := filepath.ToSlash(rel)
+		for _, elem := range strings.Split(importPath, "/") {
+			if isIgnoredDir(elem) {
+				return
+			}
+		}
+		pkg = localPackage{ImportPath: importPath, Dir: dir}
+		ok = s.keepPackage(importPath)
+		return
+	}
+	return
+}
+
+// roots returns the directories new packages are searched in with -watch,
+// according to the source settings.
+func (s *docsetSource) roots() (roots []string) {
+	if s.module != "" {
+		root, err := filepath.Abs(s.module)
+		if err == nil {
+			roots = append(roots, root)
+		}
+		return
+	}
+	if s.std == "only" {
+		return
+	}
+	for _, root := range filepath.SplitList(s.build.GOPATH) {
+		roots = append(roots, filepath.Join(root, "src"))
+	}
+	return
+}
+
+// watchedDirs returns the directories of the packages of the groups, along
+// with their parent directories up to their source root, so that new
+// packages are found.
+func watchedDirs(groups []*docsetGroup) (dirs []string) {
+	found := map[string]bool{}
+	for _, g := range groups {
+		for dir := range g.packages {
+			found[dir] = true
+			for _, root := range g.roots {
+				if !withinDir(root, dir) {
+					continue
+				}
+				for p := dir; p != root; p = filepath.Dir(p) {
+					found[filepath.Dir(p)] = true
+				}
+				break
+			}
+		}
+	}
+	for dir := range found {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return
+}
+
+// withinDir reports whether p is root or one of its subdirectories.
+func withinDir(root string, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,202 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchDelay is how long the changes must settle before regenerating, as
+// editors and tools often write several files in a row.
+const watchDelay = 300 * time.Millisecond
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// sourceWatcher watches source directories with inotify. The directories
+// created in them are watched as well.
+type sourceWatcher struct {
+	fd int
+	f  *os.File
+
+	// watched holds the directories by watch descriptor, and dirs the watch
+	// descriptors by directory.
+	watched map[int]string
+	dirs    map[string]int
+
+	// created holds the directories created since the last Wait.
+	created []string
+}
+
+func newSourceWatcher(dirs []string) (w *sourceWatcher, err error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		err = os.NewSyscallError("inotify_init1", err)
+		return
+	}
+	// a non-blocking file uses the runtime poller, so read deadlines
+	// interrupt a pending Read
+	w = &sourceWatcher{
+		fd:      fd,
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		watched: map[int]string{},
+		dirs:    map[string]int{},
+	}
+	err = w.Add(dirs...)
+	if err != nil {
+		w.Close()
+		w = nil
+	}
+	return
+}
+
+// Add watches the directories not watched yet. The directories removed in
+// the meantime are skipped.
+func (w *sourceWatcher) Add(dirs ...string) (err error) {
+	for _, dir := range dirs {
+		if _, ok := w.dirs[dir]; ok {
+			continue
+		}
+		var wd int
+		wd, err = syscall.InotifyAddWatch(w.fd, dir, watchMask)
+		if err == syscall.ENOENT {
+			err = nil
+			continue
+		}
+		if err != nil {
+			err = &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+			return
+		}
+		w.watched[wd] = dir
+		w.dirs[dir] = wd
+	}
+	return
+}
+
+// addTree watches a created directory and its subdirectories, which may
+// have been created before it was watched.
+func (w *sourceWatcher) addTree(dir string) {
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		if p != dir && isIgnoredDir(fi.Name()) {
+			return filepath.SkipDir
+		}
+		if w.Add(p) == nil {
+			w.created = append(w.created, p)
+		}
+		return nil
+	})
+}
+
+// remove stops watching a directory moved away, or removed.
+func (w *sourceWatcher) remove(dir string) {
+	wd, ok := w.dirs[dir]
+	if !ok {
+		return
+	}
+	syscall.InotifyRmWatch(w.fd, uint32(wd))
+	delete(w.watched, wd)
+	delete(w.dirs, dir)
+}
+
+// Len returns the number of watched directories.
+func (w *sourceWatcher) Len() int {
+	return len(w.dirs)
+}
+
+func (w *sourceWatcher) Close() error {
+	return w.f.Close()
+}
+
+// Wait blocks until .go files change in the watched directories, or
+// directories are created or removed, and returns the changed directories,
+// or the context error once it is canceled.
+func (w *sourceWatcher) Wait(ctx context.Context) (changed []string, err error) {
+	w.f.SetReadDeadline(time.Time{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.f.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	found := map[string]bool{}
+	for _, dir := range w.created {
+		found[dir] = true
+	}
+	w.created = nil
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		var n int
+		n, err = w.f.Read(buf)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			return
+		}
+		if err != nil {
+			if os.IsTimeout(err) && len(found) > 0 {
+				err = nil
+				break
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			name := strings.TrimRight(string(nameBytes), "\x00")
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			dir, ok := w.watched[int(event.Wd)]
+			if !ok {
+				continue
+			}
+			switch {
+			case event.Mask&syscall.IN_IGNORED != 0:
+				delete(w.watched, int(event.Wd))
+				delete(w.dirs, dir)
+			case event.Mask&syscall.IN_DELETE_SELF != 0:
+				found[dir] = true
+			case event.Mask&syscall.IN_ISDIR != 0:
+				if isIgnoredDir(name) {
+					continue
+				}
+				sub := filepath.Join(dir, name)
+				if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					w.addTree(sub)
+				} else if event.Mask&syscall.IN_MOVED_FROM != 0 {
+					w.remove(sub)
+				}
+				found[sub] = true
+			case strings.HasSuffix(name, ".go"):
+				found[dir] = true
+			}
+		}
+		for _, dir := range w.created {
+			found[dir] = true
+		}
+		w.created = nil
+		if len(found) > 0 {
+			w.f.SetReadDeadline(time.Now().Add(watchDelay))
+		}
+	}
+
+	for dir := range found {
+		changed = append(changed, dir)
+	}
+	sort.Strings(changed)
+	return
+}
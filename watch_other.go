@@ -0,0 +1,193 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watchInterval is how often the watched directories are scanned, and
+// watchDelay how long the changes must settle before regenerating, as
+// editors and tools often write several files in a row.
+const watchInterval = time.Second
+const watchDelay = 300 * time.Millisecond
+
+// sourceWatcher polls source directories where inotify is not available.
+// The directories created in them are watched as well.
+type sourceWatcher struct {
+	// dirs holds the snapshot of every watched directory.
+	dirs map[string]dirSnapshot
+
+	// created holds the directories created since the last Wait.
+	created []string
+}
+
+// dirSnapshot holds the modification time of the .go files of a directory,
+// and the names of its subdirectories.
+type dirSnapshot struct {
+	files   map[string]time.Time
+	subdirs map[string]bool
+}
+
+func newSourceWatcher(dirs []string) (w *sourceWatcher, err error) {
+	w = &sourceWatcher{dirs: map[string]dirSnapshot{}}
+	err = w.Add(dirs...)
+	return
+}
+
+// Add watches the directories not watched yet. The directories removed in
+// the meantime are skipped.
+func (w *sourceWatcher) Add(dirs ...string) (err error) {
+	for _, dir := range dirs {
+		if _, ok := w.dirs[dir]; ok {
+			continue
+		}
+		var snapshot dirSnapshot
+		snapshot, err = scanDir(dir)
+		if os.IsNotExist(err) {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		w.dirs[dir] = snapshot
+	}
+	return
+}
+
+// addTree watches a created directory and its subdirectories.
+func (w *sourceWatcher) addTree(dir string) {
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		if p != dir && isIgnoredDir(fi.Name()) {
+			return filepath.SkipDir
+		}
+		if w.Add(p) == nil {
+			w.created = append(w.created, p)
+		}
+		return nil
+	})
+}
+
+// Len returns the number of watched directories.
+func (w *sourceWatcher) Len() int {
+	return len(w.dirs)
+}
+
+func (w *sourceWatcher) Close() error {
+	return nil
+}
+
+// Wait blocks until .go files change in the watched directories, or
+// directories are created or removed, and returns the changed directories,
+// or the context error once it is canceled.
+func (w *sourceWatcher) Wait(ctx context.Context) (changed []string, err error) {
+	found := map[string]bool{}
+	for _, dir := range w.created {
+		found[dir] = true
+	}
+	w.created = nil
+	for {
+		interval := watchInterval
+		if len(found) > 0 {
+			interval = watchDelay
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(interval):
+		}
+
+		n := len(found)
+		w.scan(found)
+		for _, dir := range w.created {
+			found[dir] = true
+		}
+		w.created = nil
+		if len(found) > 0 && len(found) == n {
+			break
+		}
+	}
+
+	for dir := range found {
+		changed = append(changed, dir)
+	}
+	sort.Strings(changed)
+	return
+}
+
+// scan compares the watched directories with their snapshot, and adds the
+// changed ones to found.
+func (w *sourceWatcher) scan(found map[string]bool) {
+	var dirs []string
+	for dir := range w.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		previous, ok := w.dirs[dir]
+		if !ok {
+			continue
+		}
+		snapshot, err := scanDir(dir)
+		if err != nil {
+			// removed, or not readable anymore
+			delete(w.dirs, dir)
+			found[dir] = true
+			continue
+		}
+		w.dirs[dir] = snapshot
+
+		if len(snapshot.files) != len(previous.files) {
+			found[dir] = true
+		}
+		for name, modTime := range snapshot.files {
+			if previousTime, ok := previous.files[name]; !ok || !modTime.Equal(previousTime) {
+				found[dir] = true
+			}
+		}
+		for name := range snapshot.subdirs {
+			if !previous.subdirs[name] {
+				w.addTree(filepath.Join(dir, name))
+			}
+		}
+		for name := range previous.subdirs {
+			if !snapshot.subdirs[name] {
+				found[filepath.Join(dir, name)] = true
+			}
+		}
+	}
+}
+
+func scanDir(dir string) (snapshot dirSnapshot, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	snapshot = dirSnapshot{
+		files:   map[string]time.Time{},
+		subdirs: map[string]bool{},
+	}
+	for _, fi := range infos {
+		switch {
+		case fi.IsDir():
+			if !isIgnoredDir(fi.Name()) {
+				snapshot.subdirs[fi.Name()] = true
+			}
+		case strings.HasSuffix(fi.Name(), ".go"):
+			snapshot.files[fi.Name()] = fi.ModTime()
+		}
+	}
+	return
+}